@@ -5,8 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/joho/godotenv"
 	"github.com/pulkyeet/mev-searcher/internal/backtest"
 	"github.com/pulkyeet/mev-searcher/internal/eth"
@@ -19,6 +21,9 @@ func main() {
 		dbPath    = flag.String("db", "data/mempool.db", "Path to mempool database")
 		startBlock = flag.Uint64("start", 17916526, "Start block number")
 		endBlock   = flag.Uint64("end", 17916626, "End block number")
+		pending    = flag.Bool("pending", false, "Detect on the reserves after applying block N's pending mempool txs to N-1")
+		pendingTo  = flag.String("pending-to", "", "With --pending: only apply txs sent to these comma-separated addresses")
+		pendingMax = flag.Int("pending-max", 0, "With --pending: apply at most this many txs, highest gas price first (0 = all)")
 	)
 	flag.Parse()
 
@@ -34,6 +39,7 @@ func main() {
 		fmt.Printf("Failed to connect to RPC: %v\n", err)
 		os.Exit(1)
 	}
+	defer client.Close()
 
 	// Create runner
 	runner, err := backtest.NewRunner(client, *dbPath)
@@ -43,6 +49,21 @@ func main() {
 	}
 	defer runner.Close()
 
+	if *pending {
+		cfg := backtest.PendingConfig{Enabled: true, MaxTxs: *pendingMax}
+		for _, addr := range strings.Split(*pendingTo, ",") {
+			if addr = strings.TrimSpace(addr); addr == "" {
+				continue
+			}
+			if !common.IsHexAddress(addr) {
+				fmt.Printf("Invalid --pending-to address %q\n", addr)
+				os.Exit(1)
+			}
+			cfg.To = append(cfg.To, common.HexToAddress(addr))
+		}
+		runner.SetPending(cfg)
+	}
+
 	// Run backtest
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Hour)
 	defer cancel()
@@ -55,4 +76,5 @@ func main() {
 
 	// Print results
 	report.Print()
+	client.PrintEndpointStats()
 }
\ No newline at end of file
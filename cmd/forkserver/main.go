@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+
+	"github.com/joho/godotenv"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+	"github.com/pulkyeet/mev-searcher/internal/forkserver"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+func main() {
+	_ = godotenv.Load("../../.env")
+
+	blockNum := flag.Int64("block", 0, "Block to fork from (0 = latest)")
+	addr := flag.String("addr", "127.0.0.1:8545", "Address to serve JSON-RPC on")
+	overridesPath := flag.String("overrides", "", "JSON state override file (eth_call stateOverride format) applied at startup")
+	flag.Parse()
+
+	client, err := eth.NewClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	number := big.NewInt(*blockNum)
+	if *blockNum == 0 {
+		header, err := client.HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			log.Fatalf("Failed to fetch latest block: %v", err)
+		}
+		number = header.Number
+	}
+
+	fork, err := simulator.NewStateFork(client, number)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer fork.Close()
+
+	if *overridesPath != "" {
+		overrides, err := simulator.LoadStateOverride(*overridesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fork.ApplyOverrides(overrides); err != nil {
+			log.Fatalf("Failed to apply overrides: %v", err)
+		}
+		fmt.Printf("Applied state overrides for %d accounts\n", len(overrides))
+	}
+
+	server, err := forkserver.New(fork)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer server.Stop()
+
+	fmt.Printf("Forked mainnet at block %s\n", number)
+	fmt.Printf("Listening on http://%s\n", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -26,6 +26,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
+	defer client.Close()
 
 	parts := strings.Split(*pair, "/")
 	if len(parts) != 2 {
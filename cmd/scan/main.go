@@ -20,12 +20,22 @@ func main() {
 	blockNum := flag.Uint64("block", 18000000, "block number to scan")
 	simulateFlag := flag.Bool("simulate", false, "Simulate the arbitrage bundle")
 	pair := flag.String("pair", "WETH/USDC", "Trading pair (WETH/USDC or WETH/USDT)")
+	overridesPath := flag.String("overrides", "", "With --simulate: JSON state override file (eth_call stateOverride format)")
 	flag.Parse()
 
+	var overrides simulator.StateOverride
+	if *overridesPath != "" {
+		var err error
+		if overrides, err = simulator.LoadStateOverride(*overridesPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	client, err := eth.NewClient()
 	if err != nil {
 		log.Fatalf("failed to connect to Ethereum: %v", err)
 	}
+	defer client.Close()
 
 	ctx := context.Background()
 	blockBigInt := new(big.Int).SetUint64(*blockNum)
@@ -142,6 +152,7 @@ func main() {
 			defer fork.Close()
 
 			arbExec := arbitrage.NewArbExecutor(fork)
+			arbExec.SetOverrides(overrides)
 			simResult, err := arbExec.SimulateArbitrage(opp)
 			if err != nil {
 				log.Fatalf("Simulation error: %v", err)
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pulkyeet/mev-searcher/internal/backtest"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+// executeBuildMode packs the block from the mempool snapshot in dbPath, with
+// the --bundle txs (if any) as a candidate bundle, and reports whether the
+// bundle made it in. The bundle can be hashes of txs in the block or raw
+// signed txs, comma or newline separated, or @file
+func executeBuildMode(fork *simulator.StateFork, block *types.Block, dbPath, bundleStr string, opts simulator.BundleOptions, verbose bool) {
+	mempoolDB, err := backtest.NewMempoolDB(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mempoolDB.Close()
+
+	mempool, err := mempoolDB.GetMempoolForBlock(block.NumberU64())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var bundles []simulator.Bundle
+	if bundleStr != "" {
+		bundles = append(bundles, simulator.Bundle{Txs: parseBundleTxs(block, bundleStr), Options: opts})
+	}
+
+	fmt.Printf("Building block %d from %d mempool txs and %d bundles...\n", block.NumberU64(), len(mempool), len(bundles))
+	result, err := simulator.NewBlockBuilder(fork).Build(block, mempool, bundles)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\n=== Block Build ===\n")
+	fmt.Printf("Block:        %d\n", result.BlockNumber)
+	fmt.Printf("Transactions: %d (actual block: %d)\n", len(result.Transactions), len(block.Transactions()))
+	fmt.Printf("Gas Used:     %d / %d (actual block: %d)\n", result.GasUsed, block.GasLimit(), block.GasUsed())
+	fmt.Printf("Value:        %s ETH (fees %s, direct %s)\n",
+		formatEth(result.CoinbaseDiff), formatEth(result.GasFees),
+		formatEth(new(big.Int).Sub(result.CoinbaseDiff, result.GasFees)))
+	fmt.Printf("Burnt:        %s ETH\n", formatEth(result.BurntFees))
+	fmt.Printf("Dropped:      %d txs\n", len(result.Dropped))
+
+	for i, b := range result.Bundles {
+		if b.Included {
+			fmt.Printf("\n✓ Bundle %d included at index %d (%s gwei/gas)\n", i, b.Position, formatGwei(b.GasPrice))
+		} else {
+			fmt.Printf("\n✗ Bundle %d not included: %s\n", i, b.Reason)
+		}
+	}
+
+	if verbose {
+		fmt.Println("\nDropped txs:")
+		for _, d := range result.Dropped {
+			fmt.Printf("  %s: %s\n", d.TxHash.Hex(), d.Reason)
+		}
+	}
+	fmt.Println()
+	fork.PrintStats()
+}
+
+// parseBundleTxs decodes the --bundle list of build mode: a 32-byte hash is
+// looked up in the block, anything else is decoded as a raw signed tx
+func parseBundleTxs(block *types.Block, arg string) []*types.Transaction {
+	input, err := readArg(arg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var txs []*types.Transaction
+	for _, entry := range strings.FieldsFunc(string(input), func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	}) {
+		if len(entry) == 2+2*common.HashLength {
+			txs = append(txs, findBlockTxs(block, entry)...)
+			continue
+		}
+		tx, err := decodeRawTx(entry)
+		if err != nil {
+			log.Fatalf("Bundle tx %s: %v", entry, err)
+		}
+		txs = append(txs, tx)
+	}
+	if len(txs) == 0 {
+		log.Fatal("--bundle is empty")
+	}
+	return txs
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"math/big"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/tracers"
 	"github.com/pulkyeet/mev-searcher/internal/eth"
 	"github.com/pulkyeet/mev-searcher/internal/simulator"
 )
@@ -17,19 +23,59 @@ import (
 func main() {
 	blockNum := flag.Int64("block", 0, "Block number to fork from")
 	txHash := flag.String("tx", "", "Transaction hash to simulate")
-	bundle := flag.String("bundle", "", "Comma-separated tx hashes for bundle simulation")
+	bundle := flag.String("bundle", "", "Comma-separated tx hashes for bundle simulation (with --build also raw signed txs, or @file)")
+	allowRevert := flag.String("allow-revert", "", "With --bundle: comma-separated tx hashes that may revert without failing the bundle")
+	minTimestamp := flag.Uint64("min-timestamp", 0, "With --bundle: earliest block timestamp the bundle is valid for")
+	maxTimestamp := flag.Uint64("max-timestamp", 0, "With --bundle: latest block timestamp the bundle is valid for")
+	minBlock := flag.Uint64("min-block", 0, "With --bundle: first block number the bundle is valid for")
+	maxBlock := flag.Uint64("max-block", 0, "With --bundle: last block number the bundle is valid for")
+	build := flag.String("build", "", "Pack the block from the mempool snapshot in this db, with --bundle as a candidate bundle")
+	full := flag.Bool("full", false, "Replay every tx in the block and check it against the on-chain receipts")
+	raw := flag.String("raw", "", "Simulate a raw RLP-encoded tx (hex, or @file)")
+	call := flag.String("call", "", "Simulate an unsigned JSON call {from,to,data,value,gas,...} (inline, or @file)")
+	from := flag.String("from", "", "With --raw: sender to use instead of the signature's")
+	skipNonce := flag.Bool("skip-nonce", false, "With --raw: don't check the tx nonce")
+	index := flag.Int("index", 0, "With --raw/--call: apply this many of the block's txs first (0 = top of block)")
+	overridesPath := flag.String("overrides", "", "JSON state override file (eth_call stateOverride format) applied before simulating")
+	diffPath := flag.String("diff", "", "Write the state diff (prestateTracer diffMode JSON) to this file, - for stdout")
+	trace := flag.String("trace", "", "Trace the --tx, --raw or --call transaction: "+strings.Join(simulator.TracerNames, ", "))
+	traceConfig := flag.String("trace-config", "", "Tracer config JSON, e.g. '{\"withLog\":true}' for callTracer")
+	traceOut := flag.String("trace-out", "-", "Write the trace to this file, - for stdout")
 	verbose := flag.Bool("v", false, "Verbose output (show debug info)")
 
 	flag.Parse()
 
 	if *blockNum == 0 {
-		log.Fatal("Usage: simulate --block <number> [--tx <hash> | --bundle <hash1,hash2,...>]")
+		log.Fatal("Usage: simulate --block <number> [--tx <hash> | --bundle <hash1,hash2,...> | --build <mempool.db> | --full | --raw <tx> | --call <json>]")
+	}
+	if *trace != "" && *txHash == "" && *raw == "" && *call == "" {
+		log.Fatal("--trace needs --tx, --raw or --call")
+	}
+	tr := traceOptions{name: *trace, config: *traceConfig, out: *traceOut}
+
+	bundleOpts := simulator.BundleOptions{
+		MinTimestamp: *minTimestamp,
+		MaxTimestamp: *maxTimestamp,
+		MinBlock:     *minBlock,
+		MaxBlock:     *maxBlock,
+	}
+	for _, h := range strings.Split(*allowRevert, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		// HexToHash would quietly pad or cut a mistyped hash
+		b, err := hexutil.Decode(h)
+		if err != nil || len(b) != common.HashLength {
+			log.Fatalf("--allow-revert: %q is not a 32-byte tx hash", h)
+		}
+		bundleOpts.RevertingTxHashes = append(bundleOpts.RevertingTxHashes, common.BytesToHash(b))
 	}
 
 	client, err := eth.NewClient()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer client.Close()
 
 	ctx := context.Background()
 
@@ -43,6 +89,19 @@ func main() {
 	}
 	defer fork.Close() // ADD THIS LINE
 
+	if *overridesPath != "" {
+		overrides, err := simulator.LoadStateOverride(*overridesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := fork.ApplyOverrides(overrides); err != nil {
+			log.Fatalf("Failed to apply overrides: %v", err)
+		}
+		if *verbose {
+			fmt.Printf("Applied state overrides for %d accounts\n", len(overrides))
+		}
+	}
+
 	// Prewarm cache using debug_trace (if available)
 	if *txHash != "" {
 		txHash := common.HexToHash(*txHash)
@@ -60,15 +119,43 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Full block replay mode
+	if *full {
+		if !executeFullBlockMode(ctx, client, fork, block, *verbose) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Block building mode
+	if *build != "" {
+		executeBuildMode(fork, block, *build, *bundle, bundleOpts, *verbose)
+		return
+	}
+
 	// Bundle mode
 	if *bundle != "" {
-		executeBundleMode(ctx, client, fork, block, *bundle, *verbose)
+		executeBundleMode(ctx, client, fork, block, *bundle, bundleOpts, *diffPath, *verbose)
+		return
+	}
+
+	// What-if modes: a tx or call that isn't in the block
+	if *raw != "" || *call != "" {
+		opts := simulator.ExecOptions{SkipNonceCheck: *skipNonce}
+		if *from != "" {
+			if !common.IsHexAddress(*from) {
+				log.Fatalf("Invalid --from address %q", *from)
+			}
+			addr := common.HexToAddress(*from)
+			opts.From = &addr
+		}
+		executeWhatIfMode(fork, block, *raw, *call, opts, *index, *diffPath, tr, *verbose)
 		return
 	}
 
 	// Single tx mode
 	if *txHash != "" {
-		executeSingleTxMode(ctx, client, fork, block, *txHash, *verbose)
+		executeSingleTxMode(ctx, client, fork, block, *txHash, *diffPath, tr, *verbose)
 		return
 	}
 
@@ -80,35 +167,16 @@ func main() {
 	}
 }
 
-func executeBundleMode(ctx context.Context, client *eth.Client, fork *simulator.StateFork, block *types.Block, bundleStr string, verbose bool) {
-	hashes := strings.Split(bundleStr, ",")
-	if len(hashes) < 2 {
+func executeBundleMode(ctx context.Context, client eth.Backend, fork *simulator.StateFork, block *types.Block, bundleStr string, opts simulator.BundleOptions, diffPath string, verbose bool) {
+	bundleTxs := findBlockTxs(block, bundleStr)
+	if len(bundleTxs) < 2 {
 		log.Fatal("Bundle must contain at least 2 transactions")
 	}
 
-	// Fetch bundle txs
-	var bundleTxs []*types.Transaction
-	for _, hashStr := range hashes {
-		hashStr = strings.TrimSpace(hashStr)
-		hash := common.HexToHash(hashStr)
-
-		var found bool
-		for _, tx := range block.Transactions() {
-			if tx.Hash() == hash {
-				bundleTxs = append(bundleTxs, tx)
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			log.Fatalf("Transaction %s not found in block %d", hashStr, block.Number())
-		}
-	}
-
 	// Execute bundle
 	bundleSim := simulator.NewBundleSimulator(fork)
-	result, err := bundleSim.ExecuteBundle(bundleTxs, block)
+	bundleSim.SetTrackChanges(diffPath != "")
+	result, err := bundleSim.ExecuteBundleWithOptions(bundleTxs, block, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -119,8 +187,13 @@ func executeBundleMode(ctx context.Context, client *eth.Client, fork *simulator.
 	fmt.Printf("Transactions: %d\n", len(bundleTxs))
 	fmt.Printf("Success:     %v\n", result.Success)
 	fmt.Printf("Total Gas:   %d\n", result.TotalGasUsed)
+	fmt.Printf("Coinbase:    %s ETH (fees %s, direct %s)\n",
+		formatEth(result.CoinbaseDiff), formatEth(result.GasFees), formatEth(result.EthSentToCoinbase()))
+	fmt.Printf("Burnt:       %s ETH\n", formatEth(result.BurntFees))
 
-	if !result.Success {
+	if result.Invalid != "" {
+		fmt.Printf("Invalid:     %s\n", result.Invalid)
+	} else if !result.Success {
 		fmt.Printf("Failed at:   tx %d\n", result.RevertedAt)
 	}
 
@@ -129,18 +202,125 @@ func executeBundleMode(ctx context.Context, client *eth.Client, fork *simulator.
 		status := "✓"
 		if !txResult.Success {
 			status = "✗"
+			if result.Options.AllowsRevert(txResult.TxHash) {
+				status = "✗ (allowed)"
+			}
 		}
 		fmt.Printf("  [%d] %s %s (gas: %d, logs: %d)\n",
 			i, status, txResult.TxHash.Hex()[:10]+"...", txResult.GasUsed, len(txResult.Logs))
-		if !txResult.Success && verbose {
+		if !txResult.Success {
 			fmt.Printf("      Revert: %s\n", txResult.RevertReason)
+			if verbose && len(txResult.ReturnData) > 0 {
+				fmt.Printf("      Data:   %s\n", hexutil.Encode(txResult.ReturnData))
+			}
 		}
 	}
+	writeStateDiff(diffPath, result.StateChanges)
 	fmt.Println()
     fork.PrintStats()
 }
 
-func executeSingleTxMode(ctx context.Context, client *eth.Client, fork *simulator.StateFork, block *types.Block, txHashStr string, verbose bool) {
+// findBlockTxs looks up comma-separated tx hashes in block
+func findBlockTxs(block *types.Block, hashList string) []*types.Transaction {
+	var txs []*types.Transaction
+	for _, hashStr := range strings.Split(hashList, ",") {
+		hashStr = strings.TrimSpace(hashStr)
+		hash := common.HexToHash(hashStr)
+
+		var found bool
+		for _, tx := range block.Transactions() {
+			if tx.Hash() == hash {
+				txs = append(txs, tx)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			log.Fatalf("Transaction %s not found in block %d", hashStr, block.Number())
+		}
+	}
+	return txs
+}
+
+// executeFullBlockMode replays the whole block and reports every receipt field
+// that differs. Returns false if any tx diverged.
+func executeFullBlockMode(ctx context.Context, client eth.Backend, fork *simulator.StateFork, block *types.Block, verbose bool) bool {
+	receipts, err := client.GetBlockReceipts(ctx, block.NumberU64())
+	if err != nil {
+		log.Fatalf("Failed to fetch receipts: %v", err)
+	}
+
+	start := time.Now()
+	result, err := fork.ApplyBlock(block, receipts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	mismatches := make(map[int][]simulator.ReceiptMismatch)
+	for _, m := range result.Mismatches {
+		mismatches[m.TxIndex] = append(mismatches[m.TxIndex], m)
+	}
+
+	fmt.Printf("\n=== Block Replay ===\n")
+	fmt.Printf("Block:        %d\n", block.Number())
+	fmt.Printf("Transactions: %d\n", len(block.Transactions()))
+	fmt.Printf("Time:         %s\n\n", elapsed.Round(time.Millisecond))
+
+	var cumulative uint64
+	for i, txResult := range result.Transactions {
+		cumulative += txResult.GasUsed
+		status := "✓"
+		if len(mismatches[i]) > 0 {
+			status = "✗"
+		}
+		if verbose || len(mismatches[i]) > 0 {
+			fmt.Printf("  [%d] %s %s  status: %d/%d  gas: %d/%d  cumulative: %d/%d  logs: %d/%d\n",
+				i, status, txResult.TxHash.Hex()[:10]+"...",
+				boolStatus(txResult.Success), receipts[i].Status,
+				txResult.GasUsed, receipts[i].GasUsed,
+				cumulative, receipts[i].CumulativeGasUsed,
+				len(txResult.Logs), len(receipts[i].Logs))
+		}
+		for _, m := range mismatches[i] {
+			fmt.Printf("      %s: simulated=%s on-chain=%s\n", m.Field, m.Simulated, m.OnChain)
+		}
+	}
+
+	fmt.Printf("\nGas used:     %d simulated / %d on-chain\n", result.GasUsed, block.GasUsed())
+	if len(result.Mismatches) == 0 {
+		fmt.Printf("\n✓ ALL %d RECEIPTS MATCH\n\n", len(receipts))
+		fork.PrintStats()
+		return true
+	}
+
+	first := result.Mismatches[0]
+	fmt.Printf("\n✗ %d of %d txs diverged\n", len(mismatches), len(receipts))
+	fmt.Printf("First divergence: tx %d %s (%s)\n", first.TxIndex, first.TxHash.Hex(), first.Field)
+	if txResult := result.Transactions[first.TxIndex]; !txResult.Success {
+		fmt.Printf("Revert:           %s\n", txResult.RevertReason)
+	}
+	fmt.Println()
+	fork.PrintStats()
+	return false
+}
+
+func boolStatus(success bool) uint64 {
+	if success {
+		return types.ReceiptStatusSuccessful
+	}
+	return types.ReceiptStatusFailed
+}
+
+// traceOptions are the --trace flags
+type traceOptions struct {
+	name   string
+	config string
+	out    string
+}
+
+func executeSingleTxMode(ctx context.Context, client eth.Backend, fork *simulator.StateFork, block *types.Block, txHashStr string, diffPath string, tr traceOptions, verbose bool) {
 	hash := common.HexToHash(txHashStr)
 
 	// Find tx in block
@@ -180,6 +360,8 @@ func executeSingleTxMode(ctx context.Context, client *eth.Client, fork *simulato
 
 	// Execute target tx
 	executor := simulator.NewExecutor(fork)
+	executor.SetTrackChanges(diffPath != "")
+	tracer := attachTracer(executor, tr, block, txIndex, targetTx.Hash())
 	result, err := executor.ExecuteTransaction(targetTx, block)
 	if err != nil {
 		log.Fatal(err)
@@ -203,14 +385,7 @@ func executeSingleTxMode(ctx context.Context, client *eth.Client, fork *simulato
 		fmt.Printf("To:       CONTRACT_CREATION\n")
 	}
 
-	fmt.Printf("\n--- Simulation ---\n")
-	fmt.Printf("Success:  %v\n", result.Success)
-	fmt.Printf("Gas Used: %d\n", result.GasUsed)
-	fmt.Printf("Logs:     %d events\n", len(result.Logs))
-
-	if !result.Success {
-		fmt.Printf("Revert:   %s\n", result.RevertReason)
-	}
+	printSimulation(result, verbose)
 
 	if receipt != nil {
 		fmt.Printf("\n--- On-Chain Receipt ---\n")
@@ -225,10 +400,114 @@ func executeSingleTxMode(ctx context.Context, client *eth.Client, fork *simulato
 			fmt.Printf("\n⚠ Gas mismatch: %+d (%.2f%%)\n", diff, float64(diff)/float64(receipt.GasUsed)*100)
 		}
 	}
+	writeStateDiff(diffPath, result.StateChanges)
+	if tracer != nil {
+		writeTrace(tr, tracer)
+	}
 	fmt.Println()
     fork.PrintStats()
 }
 
+// printSimulation prints the "--- Simulation ---" section for one tx
+func printSimulation(result *simulator.SimulationResult, verbose bool) {
+	fmt.Printf("\n--- Simulation ---\n")
+	fmt.Printf("Success:  %v\n", result.Success)
+	fmt.Printf("Gas Used: %d\n", result.GasUsed)
+	fmt.Printf("Logs:     %d events\n", len(result.Logs))
+	// fees are unset when the tx couldn't be included at all
+	if result.EffectiveGasPrice != nil {
+		fmt.Printf("Gas Price: %s gwei (tip %s gwei)\n", formatGwei(result.EffectiveGasPrice), formatGwei(result.PriorityFee))
+		fmt.Printf("Coinbase: %s ETH\n", formatEth(result.CoinbaseDiff))
+		fmt.Printf("Burnt:    %s ETH\n", formatEth(result.BurntFees))
+		if result.BlobGasUsed > 0 {
+			fmt.Printf("Blob Gas: %d (%s ETH)\n", result.BlobGasUsed, formatEth(result.BlobFees))
+		}
+	}
+
+	if !result.Success {
+		fmt.Printf("Revert:   %s\n", result.RevertReason)
+		if verbose && len(result.ReturnData) > 0 {
+			fmt.Printf("Data:     %s\n", hexutil.Encode(result.ReturnData))
+		}
+	} else if len(result.ReturnData) > 0 {
+		fmt.Printf("Return:   %s\n", hexutil.Encode(result.ReturnData))
+	}
+}
+
+// attachTracer sets up the --trace tracer on executor, nil if none was asked for
+func attachTracer(executor *simulator.Executor, tr traceOptions, block *types.Block, txIndex int, txHash common.Hash) *tracers.Tracer {
+	if tr.name == "" {
+		return nil
+	}
+	var cfg json.RawMessage
+	if tr.config != "" {
+		cfg = json.RawMessage(tr.config)
+	}
+	tracer, err := simulator.NewTracer(tr.name, cfg, &tracers.Context{
+		BlockHash:   block.Hash(),
+		BlockNumber: block.Number(),
+		TxIndex:     txIndex,
+		TxHash:      txHash,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	executor.SetTracer(tracer.Hooks)
+	return tracer
+}
+
+// writeTrace prints or saves the tracer output, same JSON as debug_traceTransaction
+func writeTrace(tr traceOptions, tracer *tracers.Tracer) {
+	raw, err := tracer.GetResult()
+	if err != nil {
+		log.Fatalf("Trace failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		log.Fatalf("Failed to format trace: %v", err)
+	}
+	if tr.out == "-" {
+		fmt.Printf("\n--- Trace (%s) ---\n%s\n", tr.name, out.String())
+		return
+	}
+	if err := os.WriteFile(tr.out, out.Bytes(), 0644); err != nil {
+		log.Fatalf("Failed to write trace: %v", err)
+	}
+	fmt.Printf("Trace written to %s\n", tr.out)
+}
+
+// writeStateDiff dumps changes as JSON, in the same shape as
+// debug_traceTransaction with prestateTracer {diffMode: true}
+func writeStateDiff(path string, changes *simulator.StateChanges) {
+	if path == "" {
+		return
+	}
+	if changes == nil {
+		fmt.Println("No state diff: transaction was not executed")
+		return
+	}
+	out, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode state diff: %v", err)
+	}
+	if path == "-" {
+		fmt.Printf("\n--- State Diff ---\n%s\n", out)
+		return
+	}
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		log.Fatalf("Failed to write state diff: %v", err)
+	}
+	fmt.Printf("State diff written to %s\n", path)
+}
+
+func formatEth(wei *big.Int) string {
+	return new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e18)).Text('f', 6)
+}
+
+func formatGwei(wei *big.Int) string {
+	return new(big.Float).Quo(new(big.Float).SetInt(wei), big.NewFloat(1e9)).Text('f', 2)
+}
+
 func getFrom(tx *types.Transaction) string {
 	signer := types.LatestSignerForChainID(tx.ChainId())
 	from, err := types.Sender(signer, tx)
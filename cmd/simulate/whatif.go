@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+// readArg returns the flag value, or the contents of the file for @path
+func readArg(arg string) ([]byte, error) {
+	if strings.HasPrefix(arg, "@") {
+		return os.ReadFile(arg[1:])
+	}
+	return []byte(arg), nil
+}
+
+func decodeRawTx(arg string) (*types.Transaction, error) {
+	input, err := readArg(arg)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hexutil.Decode(strings.TrimSpace(string(input)))
+	if err != nil {
+		return nil, fmt.Errorf("raw tx is not hex: %w", err)
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(b); err != nil {
+		return nil, fmt.Errorf("failed to decode raw tx: %w", err)
+	}
+	return tx, nil
+}
+
+func decodeCall(arg string) (ethereum.CallMsg, error) {
+	input, err := readArg(arg)
+	if err != nil {
+		return ethereum.CallMsg{}, err
+	}
+	var args simulator.CallArgs
+	if err := json.Unmarshal(input, &args); err != nil {
+		return ethereum.CallMsg{}, fmt.Errorf("failed to decode call: %w", err)
+	}
+	return args.ToCallMsg(), nil
+}
+
+// executeWhatIfMode simulates a tx or call that is not part of the block, after
+// the block's first index txs
+func executeWhatIfMode(fork *simulator.StateFork, block *types.Block, rawArg, callArg string, opts simulator.ExecOptions, index int, diffPath string, tr traceOptions, verbose bool) {
+	if index < 0 || index > len(block.Transactions()) {
+		log.Fatalf("--index %d out of range, block %d has %d txs", index, block.Number(), len(block.Transactions()))
+	}
+
+	executor := simulator.NewExecutor(fork)
+	if index > 0 {
+		if verbose {
+			fmt.Printf("Applying %d prior transactions...\n", index)
+		}
+		for i := 0; i < index; i++ {
+			if _, err := executor.ExecuteTransaction(block.Transactions()[i], block); err != nil {
+				log.Fatalf("Failed to apply prior tx %d: %v", i, err)
+			}
+		}
+	}
+
+	var (
+		tx   *types.Transaction
+		call ethereum.CallMsg
+		from common.Address
+		to   *common.Address
+		hash common.Hash
+		err  error
+	)
+	if rawArg != "" {
+		if tx, err = decodeRawTx(rawArg); err != nil {
+			log.Fatal(err)
+		}
+		hash, to = tx.Hash(), tx.To()
+		if opts.From != nil {
+			from = *opts.From
+		} else if from, err = types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err != nil {
+			log.Fatalf("Can't recover sender (unsigned tx? use --from): %v", err)
+		}
+	} else {
+		if call, err = decodeCall(callArg); err != nil {
+			log.Fatal(err)
+		}
+		from, to = call.From, call.To
+	}
+
+	// only the tx itself is diffed, not the ones before it
+	executor.SetTrackChanges(diffPath != "")
+	tracer := attachTracer(executor, tr, block, index, hash)
+	var result *simulator.SimulationResult
+	if tx != nil {
+		result, err = executor.ExecuteTransactionWithOptions(tx, block, opts)
+	} else {
+		result, err = executor.ExecuteCall(call, block)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\n=== What-If Simulation ===\n")
+	fmt.Printf("Block:    %d\n", block.Number())
+	fmt.Printf("Index:    %d\n", index)
+	if tx != nil {
+		fmt.Printf("Hash:     %s\n", hash.Hex())
+	}
+	fmt.Printf("From:     %s\n", from.Hex())
+	if to != nil {
+		fmt.Printf("To:       %s\n", to.Hex())
+	} else {
+		fmt.Printf("To:       CONTRACT_CREATION\n")
+	}
+
+	printSimulation(result, verbose)
+	writeStateDiff(diffPath, result.StateChanges)
+	if tracer != nil {
+		writeTrace(tr, tracer)
+	}
+	fmt.Println()
+	fork.PrintStats()
+}
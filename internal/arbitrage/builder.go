@@ -15,6 +15,9 @@ var (
 	UniswapV2Router = common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D")
 	SushiswapRouter = common.HexToAddress("0xd9e1cE17f2641f24aE83637ab66a2cca9C378B9F")
 	
+	// gas limit used when a swap can't be estimated on the fork
+	DefaultSwapGas uint64 = 150000
+
 	// Router ABI - only the function we need
 	routerABI = `[{
 		"inputs": [
@@ -55,20 +58,21 @@ func BuildSwapCalldata(
 	return calldata, nil
 }
 
-// return 2 swap transactions for an arbitrage
+// return 2 swap transactions for an arbitrage. Gas is DefaultSwapGas and the
+// access lists are empty; ArbExecutor fills both in from the fork.
 
 func BuildArbTransactions(
 	opp *Opportunity,
 	executor common.Address,
 	blockTimestamp uint64,
 	baseFee *big.Int,
-) ([]*types.LegacyTx, error) {
+) ([]*types.AccessListTx, error) {
 	// deadline = blocktimestamp + 2 minutes
 	deadline := new(big.Int).Add(big.NewInt(int64(blockTimestamp)), big.NewInt(120))
 
 	gasPrice := new(big.Int).Add(baseFee, big.NewInt(2e9)) // baseFee + 2 gwei tip
 
-	txs := make([]*types.LegacyTx, 2)
+	txs := make([]*types.AccessListTx, 2)
 
 	// tx 1: buy on cheap pool
 	buyPath := []common.Address{
@@ -89,12 +93,13 @@ func BuildArbTransactions(
 	if err!=nil {
 		return nil, fmt.Errorf("failed to build buy calldata: %w", err)
 	}
-	txs[0] = &types.LegacyTx{
+	txs[0] = &types.AccessListTx{
+		ChainID:  big.NewInt(1),
 		Nonce: 0,
 		To:       &buyRouter,
 		Value:    big.NewInt(0),
-		Gas:      150000,  // Estimated gas for single swap
-		GasPrice: gasPrice, // 30 gwei
+		Gas:      DefaultSwapGas,
+		GasPrice: gasPrice,
 		Data:     buyCalldata,
 	}
 
@@ -124,11 +129,12 @@ func BuildArbTransactions(
 		return nil, fmt.Errorf("failed to build sell calldata: %w", err)
 	}
 
-	txs[1] = &types.LegacyTx{
+	txs[1] = &types.AccessListTx{
+		ChainID:  big.NewInt(1),
 		Nonce: 1,
 		To:       &sellRouter,
 		Value:    big.NewInt(0),
-		Gas:      150000,
+		Gas:      DefaultSwapGas,
 		GasPrice: gasPrice,
 		Data:     sellCalldata,
 	}
@@ -1,104 +1,85 @@
 package arbitrage
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
 	"github.com/pulkyeet/mev-searcher/internal/simulator"
 )
 
 type ArbExecutor struct {
-	fork *simulator.StateFork
+	fork      *simulator.StateFork
+	overrides simulator.StateOverride
 }
 
 func NewArbExecutor(fork *simulator.StateFork) *ArbExecutor {
 	return &ArbExecutor{fork: fork}
 }
 
-// gives the executor USDC and token approvals
-
-func (e *ArbExecutor) SetupExecutorState(executor common.Address, usdcAmount *big.Int) error {
-	// Give executor USDC balance
-	usdcAddr := common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
-	e.fork.SetBalance(executor, big.NewInt(1e18)) // 1 ETH for gas
-
-	// Set USDC balance in storage
-	// USDC uses slot: keccak256(abi.encode(address, uint256(9)))
-	// where 9 is the balances mapping slot
-	slot := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(executor.Bytes(), 32),
-			common.LeftPadBytes(big.NewInt(9).Bytes(), 32)...,
-		),
-	)
-
-	// Store USDC balance
-	balanceBytes := common.LeftPadBytes(usdcAmount.Bytes(), 32)
-	e.fork.SetStorageAt(usdcAddr, slot, common.BytesToHash(balanceBytes))
-
-	// Approve Uniswap router for max USDC
-	maxApproval := new(big.Int).SetBytes(common.Hex2Bytes("ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"))
-
-	// Approval slot for Uniswap: keccak256(abi.encode(router, keccak256(abi.encode(executor, 10))))
-	// where 10 is the allowances mapping slot
-	innerHash := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(executor.Bytes(), 32),
-			common.LeftPadBytes(big.NewInt(10).Bytes(), 32)...,
-		),
-	)
-
-	uniRouter := common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D")
-	approvalSlot := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(uniRouter.Bytes(), 32),
-			innerHash.Bytes()...,
-		),
-	)
-
-	e.fork.SetStorageAt(usdcAddr, approvalSlot, common.BytesToHash(maxApproval.Bytes()))
-
-	// Same for Sushiswap router
-	sushiRouter := common.HexToAddress("0xd9e1cE17f2641f24aE83637ab66a2cca9C378B9F")
-	approvalSlotSushi := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(sushiRouter.Bytes(), 32),
-			innerHash.Bytes()...,
-		),
-	)
-
-	e.fork.SetStorageAt(usdcAddr, approvalSlotSushi, common.BytesToHash(maxApproval.Bytes()))
+// SetOverrides sets extra state (e.g. our own executor contract and its
+// funding) applied on top of the default setup before every simulation
+func (e *ArbExecutor) SetOverrides(o simulator.StateOverride) {
+	e.overrides = o
+}
 
-	// Also approve WETH (we'll need this for the sell side)
-	wethAddr := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+var (
+	uniRouter   = common.HexToAddress("0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D")
+	sushiRouter = common.HexToAddress("0xd9e1cE17f2641f24aE83637ab66a2cca9C378B9F")
 
-	wethInnerHash := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(executor.Bytes(), 32),
-			common.LeftPadBytes(big.NewInt(2).Bytes(), 32)..., // WETH allowances slot is 2
-		),
-	)
+	maxApproval = common.MaxHash
+)
 
-	wethApprovalUni := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(uniRouter.Bytes(), 32),
-			wethInnerHash.Bytes()...,
-		),
-	)
+// ExecutorOverrides is the state SetupExecutorState writes: 1 ETH for gas,
+// usdcAmount USDC and max USDC/WETH approvals for both routers. The token
+// storage slots are found with DiscoverTokenSlots.
+func ExecutorOverrides(fork *simulator.StateFork, executor common.Address, usdcAmount *big.Int) (simulator.StateOverride, error) {
+	balance, err := fork.TokenBalanceSlot(eth.USDCAddress, executor)
+	if err != nil {
+		return nil, err
+	}
+	usdc := map[common.Hash]common.Hash{balance: common.BigToHash(usdcAmount)}
+	weth := map[common.Hash]common.Hash{}
+	for _, router := range []common.Address{uniRouter, sushiRouter} {
+		slot, err := fork.TokenAllowanceSlot(eth.USDCAddress, executor, router)
+		if err != nil {
+			return nil, err
+		}
+		usdc[slot] = maxApproval
+		if slot, err = fork.TokenAllowanceSlot(eth.WETHAddress, executor, router); err != nil {
+			return nil, err
+		}
+		weth[slot] = maxApproval
+	}
 
-	wethApprovalSushi := crypto.Keccak256Hash(
-		append(
-			common.LeftPadBytes(sushiRouter.Bytes(), 32),
-			wethInnerHash.Bytes()...,
-		),
-	)
+	return simulator.StateOverride{
+		executor:        {Balance: (*hexutil.Big)(big.NewInt(1e18))},
+		eth.USDCAddress: {StateDiff: usdc},
+		eth.WETHAddress: {StateDiff: weth},
+	}, nil
+}
 
-	e.fork.SetStorageAt(wethAddr, wethApprovalUni, common.BytesToHash(maxApproval.Bytes()))
-	e.fork.SetStorageAt(wethAddr, wethApprovalSushi, common.BytesToHash(maxApproval.Bytes()))
+// gives the executor USDC and token approvals, plus any SetOverrides state
 
+func (e *ArbExecutor) SetupExecutorState(executor common.Address, usdcAmount *big.Int) error {
+	overrides, err := ExecutorOverrides(e.fork, executor, usdcAmount)
+	if err != nil {
+		return err
+	}
+	if err := e.fork.ApplyOverrides(overrides); err != nil {
+		return err
+	}
+	if e.overrides != nil {
+		if err := e.fork.ApplyOverrides(e.overrides); err != nil {
+			return fmt.Errorf("failed to apply overrides: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -107,8 +88,11 @@ func (e *ArbExecutor) SetupExecutorState(executor common.Address, usdcAmount *bi
 func (e *ArbExecutor) SimulateArbitrage(opp *Opportunity) (*SimulationResult, error) {
 	block := e.fork.BlockContext()
 
-	// generating a fake executor address
-	privateKey, _ := crypto.GenerateKey()
+	// fixed executor key so repeated runs touch the same state (and replay from a cassette)
+	privateKey, err := crypto.ToECDSA(crypto.Keccak256([]byte("mev-searcher/sim-executor")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive executor key: %w", err)
+	}
 	executor := crypto.PubkeyToAddress(privateKey.PublicKey)
 
 	// Setup executor state (give USDC balance + approvals)
@@ -117,21 +101,14 @@ func (e *ArbExecutor) SimulateArbitrage(opp *Opportunity) (*SimulationResult, er
 		return nil, fmt.Errorf("failed to setup executor state: %w", err)
 	}
 
-	legacyTxs, err := BuildArbTransactions(opp, executor, block.Time(), block.BaseFee())
+	arbTxs, err := BuildArbTransactions(opp, executor, block.Time(), block.BaseFee())
 	if err != nil {
 		return nil, fmt.Errorf("failed to build transactions: %w", err)
 	}
 
-	signer := types.LatestSignerForChainID(big.NewInt(1))
-
-	txs := make([]*types.Transaction, len(legacyTxs))
-	for i, legacyTx := range legacyTxs {
-		tx := types.NewTx(legacyTx)
-		signedTx, err := types.SignTx(tx, signer, privateKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to sign tx %d: %w", i, err)
-		}
-		txs[i] = signedTx
+	txs, err := e.prepareTransactions(arbTxs, executor, privateKey, block)
+	if err != nil {
+		return nil, err
 	}
 
 	// executing bundle
@@ -146,7 +123,9 @@ func (e *ArbExecutor) SimulateArbitrage(opp *Opportunity) (*SimulationResult, er
 			Success:      false,
 			EstProfit:    opp.EstProfit,
 			ActualProfit: big.NewInt(0),
+			RevertedAt:   bundleResult.RevertedAt,
 			RevertReason: getRevertReason(bundleResult),
+			TxResults:    bundleResult.Transactions,
 		}, nil
 	}
 
@@ -162,12 +141,55 @@ func (e *ArbExecutor) SimulateArbitrage(opp *Opportunity) (*SimulationResult, er
 	}, nil
 }
 
+// prepareTransactions sets each tx's access list and gas limit from the fork
+// and signs it. Every tx is applied before the next one is estimated, since
+// the sell swap only works after the buy; the fork is restored afterwards.
+// A tx that can't be estimated keeps DefaultSwapGas so the bundle simulation
+// reports why it fails.
+func (e *ArbExecutor) prepareTransactions(arbTxs []*types.AccessListTx, from common.Address, key *ecdsa.PrivateKey, block *types.Block) ([]*types.Transaction, error) {
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	sim := simulator.NewExecutor(e.fork)
+
+	snap := e.fork.Snapshot()
+	defer e.fork.RevertToSnapshot(snap)
+
+	txs := make([]*types.Transaction, len(arbTxs))
+	for i, arbTx := range arbTxs {
+		call := ethereum.CallMsg{
+			From:     from,
+			To:       arbTx.To,
+			GasPrice: arbTx.GasPrice,
+			Value:    arbTx.Value,
+			Data:     arbTx.Data,
+		}
+		if al, err := sim.CreateAccessList(call, block); err == nil && al.RevertReason == "" {
+			call.AccessList = al.AccessList
+			if gas, err := sim.EstimateGas(call, block); err == nil {
+				arbTx.AccessList = al.AccessList
+				arbTx.Gas = gas + gas/10 // headroom for reserves moving before inclusion
+			}
+		}
+
+		signedTx, err := types.SignNewTx(key, signer, arbTx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign tx %d: %w", i, err)
+		}
+		txs[i] = signedTx
+
+		if _, err := sim.ExecuteTransaction(signedTx, block); err != nil {
+			return nil, fmt.Errorf("failed to apply tx %d: %w", i, err)
+		}
+	}
+	return txs, nil
+}
+
 type SimulationResult struct {
 	Success      bool
 	EstProfit    *big.Int
 	ActualProfit *big.Int
 	GasUsed      uint64
-	RevertReason string
+	RevertedAt   int    // bundle tx that failed
+	RevertReason string // decoded, e.g. "execution reverted: UniswapV2Router: INSUFFICIENT_OUTPUT_AMOUNT"
 	TxResults    []*simulator.TxResult
 }
 
@@ -179,6 +201,9 @@ func extractProfitFromLogs(result *simulator.BundleResult, opp *Opportunity) *bi
 }
 
 func getRevertReason(result *simulator.BundleResult) string {
+	if result.Invalid != "" {
+		return result.Invalid
+	}
 	if result.RevertedAt >= 0 && result.RevertedAt < len(result.Transactions) {
 		return result.Transactions[result.RevertedAt].RevertReason
 	}
@@ -189,7 +214,7 @@ func getRevertReason(result *simulator.BundleResult) string {
 
 func (r *SimulationResult) CompareResults() string {
 	if !r.Success {
-		return fmt.Sprintf("❌ Simulation FAILED: %s", r.RevertReason)
+		return fmt.Sprintf("❌ Simulation FAILED at bundle tx %d: %s", r.RevertedAt, r.RevertReason)
 	}
 
 	estFloat := new(big.Float).SetInt(r.EstProfit)
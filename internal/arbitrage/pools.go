@@ -42,7 +42,7 @@ func sortTokens(
 // FetchReserves — unchanged, calls getReserves() on a pool contract
 func FetchReserves(
 	ctx context.Context,
-	client *eth.Client,
+	client eth.Backend,
 	poolAddress common.Address,
 	blockNum *big.Int,
 ) (reserve0, reserve1 *big.Int, err error) {
@@ -84,7 +84,7 @@ func FetchReserves(
 // LoadPool — no longer calls FetchTokens, caller provides token0/token1
 func LoadPool(
 	ctx context.Context,
-	client *eth.Client,
+	client eth.Backend,
 	poolAddress common.Address,
 	dex string,
 	blockNum *big.Int,
@@ -109,7 +109,7 @@ func LoadPool(
 // Pools with zero reserves (inactive) are skipped silently
 func GetPairPools(
 	ctx context.Context,
-	client *eth.Client,
+	client eth.Backend,
 	blockNum *big.Int,
 	tokenA common.Address, tokenADec int,
 	tokenB common.Address, tokenBDec int,
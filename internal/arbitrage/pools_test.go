@@ -0,0 +1,45 @@
+package arbitrage
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestGetPairPools(t *testing.T) {
+	node := testnode.New()
+	defer node.Close()
+
+	const block = 18_000_000
+	token0, _, token1, _ := sortTokens(eth.WETHAddress, eth.WETHDecimals, eth.USDCAddress, eth.USDCDecimals)
+
+	// uniswap and sushiswap are live, shibaswap has no pair deployed
+	uni := ComputePairAddress(eth.KnownDEXes[0], token0, token1)
+	sushi := ComputePairAddress(eth.KnownDEXes[1], token0, token1)
+	node.DeployPair(block, uni, big.NewInt(50_000_000e6), big.NewInt(9e18))
+	node.DeployPair(block, sushi, big.NewInt(10_000_000e6), big.NewInt(2e18))
+	node.AddBlock(block, nil, nil)
+
+	client, err := eth.DialClient(node.URL())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	pools, err := GetPairPools(context.Background(), client, big.NewInt(block),
+		eth.WETHAddress, eth.WETHDecimals, eth.USDCAddress, eth.USDCDecimals)
+	if err != nil {
+		t.Fatalf("GetPairPools: %v", err)
+	}
+	if len(pools.Pools) != 2 {
+		t.Fatalf("got %d pools, want 2", len(pools.Pools))
+	}
+	if pools.Pools[0].Address != uni || pools.Pools[0].DEX != "uniswap" {
+		t.Errorf("first pool %s (%s), want uniswap %s", pools.Pools[0].Address.Hex(), pools.Pools[0].DEX, uni.Hex())
+	}
+	if pools.Pools[1].Reserve0.Cmp(big.NewInt(10_000_000e6)) != 0 {
+		t.Errorf("sushiswap reserve0 = %s", pools.Pools[1].Reserve0)
+	}
+}
@@ -99,7 +99,7 @@ func swapDirection(log *types.Log) int {
 	return 0
 }
 
-func FindActualArbitrages(ctx context.Context, client *eth.Client, blockNum uint64) ([]*ActualArbitrage, error) {
+func FindActualArbitrages(ctx context.Context, client eth.Backend, blockNum uint64) ([]*ActualArbitrage, error) {
 	block, err := client.BlockByNumber(ctx, new(big.Int).SetUint64(blockNum))
 	if err != nil {
 		return nil, fmt.Errorf("fetch block %d: %w", blockNum, err)
@@ -0,0 +1,81 @@
+package backtest
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/arbitrage"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestFindActualArbitrages(t *testing.T) {
+	node := testnode.New()
+	defer node.Close()
+
+	const block = 18_000_000
+	token0, token1 := sortAddrs(eth.WETHAddress, eth.USDCAddress)
+	uni := arbitrage.ComputePairAddress(eth.KnownDEXes[0], token0, token1)
+	sushi := arbitrage.ComputePairAddress(eth.KnownDEXes[1], token0, token1)
+
+	key, _ := crypto.GenerateKey()
+	searcher := crypto.PubkeyToAddress(key.PublicKey)
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	newTx := func(nonce uint64) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: big.NewInt(50e9),
+			Gas:       300000,
+			To:        &searcher,
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return tx
+	}
+
+	zero, amt := new(big.Int), big.NewInt(1000)
+	arbTx, swapTx := newTx(0), newTx(1)
+	receipts := []*types.Receipt{
+		// buy token1 on uniswap, sell it on sushiswap
+		{Status: types.ReceiptStatusSuccessful, GasUsed: 180000, Logs: []*types.Log{
+			testnode.SwapLog(uni, searcher, sushi, amt, zero, zero, amt),
+			testnode.SwapLog(sushi, searcher, searcher, zero, amt, amt, zero),
+		}},
+		// a plain swap on one pool is not an arb
+		{Status: types.ReceiptStatusSuccessful, GasUsed: 120000, Logs: []*types.Log{
+			testnode.SwapLog(uni, searcher, searcher, amt, zero, zero, amt),
+		}},
+	}
+	node.AddBlock(block, []*types.Transaction{arbTx, swapTx}, receipts)
+
+	client, err := eth.DialClient(node.URL())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	arbs, err := FindActualArbitrages(context.Background(), client, block)
+	if err != nil {
+		t.Fatalf("FindActualArbitrages: %v", err)
+	}
+	if len(arbs) != 1 {
+		t.Fatalf("found %d arbs, want 1", len(arbs))
+	}
+	arb := arbs[0]
+	if arb.TxHash != arbTx.Hash() || arb.From != searcher || arb.GasUsed != 180000 {
+		t.Errorf("unexpected arb: %+v", arb)
+	}
+	hit := map[common.Address]bool{}
+	for _, p := range arb.PoolsHit {
+		hit[p] = true
+	}
+	if len(hit) != 2 || !hit[uni] || !hit[sushi] {
+		t.Errorf("pools hit = %v, want uniswap and sushiswap pairs", arb.PoolsHit)
+	}
+}
@@ -0,0 +1,59 @@
+package backtest
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+// applyPending packs the chosen mempool txs for block onto fork (at its
+// parent) in block's context, highest gas price first and in nonce order per
+// sender, up to MaxTxs of them. Txs that can't be included are skipped.
+// Returns how many went in.
+func (r *Runner) applyPending(fork *simulator.StateFork, block *types.Block) (int, error) {
+	mempool, err := r.mempoolDB.GetMempoolForBlock(block.NumberU64())
+	if err != nil {
+		return 0, fmt.Errorf("load mempool: %w", err)
+	}
+
+	txs := selectPending(mempool, r.pending)
+	if len(txs) == 0 {
+		return 0, nil
+	}
+	// the builder caps the count after queueing txs per sender, so a
+	// sender's later nonces never go in without the earlier ones
+	builder := simulator.NewBlockBuilder(fork)
+	builder.SetMaxTxs(r.pending.MaxTxs)
+	result, err := builder.Apply(block, txs, nil)
+	if err != nil {
+		return 0, fmt.Errorf("apply pending txs: %w", err)
+	}
+	return len(result.Transactions), nil
+}
+
+// selectPending filters the mempool by cfg's recipients
+func selectPending(mempool []*types.Transaction, cfg PendingConfig) []*types.Transaction {
+	if len(cfg.To) == 0 {
+		return mempool
+	}
+	var txs []*types.Transaction
+	for _, tx := range mempool {
+		if sentToAny(tx, cfg) {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func sentToAny(tx *types.Transaction, cfg PendingConfig) bool {
+	if tx.To() == nil {
+		return false
+	}
+	for _, addr := range cfg.To {
+		if *tx.To() == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package backtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/pulkyeet/mev-searcher/internal/arbitrage"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestProcessBlockPending(t *testing.T) {
+	const block = 18_000_001
+	token0, token1 := sortAddrs(eth.WETHAddress, eth.USDCAddress)
+	uni := arbitrage.ComputePairAddress(eth.KnownDEXes[0], token0, token1)
+	sushi := arbitrage.ComputePairAddress(eth.KnownDEXes[1], token0, token1)
+	usdc, weth := big.NewInt(2_000_000e6), new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+
+	key, _ := crypto.GenerateKey()
+	trader := crypto.PubkeyToAddress(key.PublicKey)
+
+	node := testnode.New()
+	defer node.Close()
+	node.SetBalance(block-1, trader, big.NewInt(1e18))
+	node.DeployPair(block-1, uni, usdc, weth)
+	// sushiswap's stand-in also takes a raw reserves word to store, so a
+	// pending tx can move its price: 32 bytes of calldata -> sstore(8, word)
+	pairCode := testnode.PairCode()
+	code := append(common.FromHex(fmt.Sprintf("36602014"+"60%02x57", 7+len(pairCode))), pairCode...)
+	code = append(code, common.FromHex("5b60003560085500")...)
+	node.SetCode(block-1, sushi, code)
+	node.SetStorage(block-1, sushi, testnode.PairReservesSlot, testnode.PackReserves(usdc, weth, 0))
+	node.AddBlock(block-1, nil, nil)
+	node.AddBlock(block, nil, nil)
+
+	// a pending tx pushes sushiswap's WETH price up 10%
+	moved := testnode.PackReserves(big.NewInt(2_200_000e6), weth, 0)
+	tx, _ := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+		ChainID: big.NewInt(1), Nonce: 0, GasTipCap: big.NewInt(2e9), GasFeeCap: big.NewInt(100e9),
+		Gas: 100000, To: &sushi, Data: moved.Bytes(),
+	})
+	raw, _ := rlp.EncodeToBytes(tx)
+
+	dbPath := filepath.Join(t.TempDir(), "mempool.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("open mempool db: %v", err)
+	}
+	schema, err := os.ReadFile("mempool_schema.sql")
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	blockTime := node.Header(block).Time
+	if _, err := db.Exec(`INSERT INTO mempool_txs (tx_hash, timestamp, included_block, included_block_timestamp, raw_tx, tx_from, tx_to)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		tx.Hash().Hex(), blockTime-3, block, blockTime, common.Bytes2Hex(raw), trader.Hex(), sushi.Hex()); err != nil {
+		t.Fatalf("insert mempool tx: %v", err)
+	}
+	db.Close()
+
+	client, err := eth.DialClient(node.URL())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	runner, err := NewRunner(client, dbPath)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	defer runner.Close()
+	runner.SetCacheDBPath(filepath.Join(t.TempDir(), "state_cache.db"))
+	ctx := context.Background()
+
+	// at N-1 the pools agree
+	result, err := runner.ProcessBlock(ctx, block)
+	if err != nil {
+		t.Fatalf("ProcessBlock: %v", err)
+	}
+	if len(result.Predicted) != 0 || result.PendingTxs != 0 {
+		t.Errorf("without pending txs: predicted %d, pending %d", len(result.Predicted), result.PendingTxs)
+	}
+
+	// txs to other contracts are left out
+	runner.SetPending(PendingConfig{Enabled: true, To: []common.Address{uni}})
+	if result, err = runner.ProcessBlock(ctx, block); err != nil || result.PendingTxs != 0 || len(result.Predicted) != 0 {
+		t.Errorf("filtered pending: %v %+v", err, result)
+	}
+
+	runner.SetPending(PendingConfig{Enabled: true, To: []common.Address{sushi}, MaxTxs: 10})
+	result, err = runner.ProcessBlock(ctx, block)
+	if err != nil {
+		t.Fatalf("ProcessBlock with pending: %v", err)
+	}
+	if result.PendingTxs != 1 || len(result.Predicted) != 1 {
+		t.Fatalf("with pending txs: predicted %d, pending %d", len(result.Predicted), result.PendingTxs)
+	}
+	if opp := result.Predicted[0]; opp.BlockNumber != block {
+		t.Errorf("opportunity = %+v", opp)
+	}
+
+	// the chain itself is untouched
+	if r0, _, err := arbitrage.FetchReserves(ctx, client, sushi, big.NewInt(block-1)); err != nil || r0.Cmp(usdc) != 0 {
+		t.Errorf("sushiswap reserve0 at N-1 = %s, %v", r0, err)
+	}
+}
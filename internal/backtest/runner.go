@@ -7,16 +7,19 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/pulkyeet/mev-searcher/internal/arbitrage"
 	"github.com/pulkyeet/mev-searcher/internal/eth"
 	"github.com/pulkyeet/mev-searcher/internal/simulator"
 )
 
 type Runner struct {
-	client    *eth.Client
+	client    eth.Backend
 	mempoolDB *MempoolDB
 	gasPrice  *big.Int
 	gasLimit  *big.Int
+	pending   PendingConfig
+	cacheDB   string // state cache of the forks made in pending mode
 }
 
 type pairDef struct {
@@ -34,7 +37,7 @@ var trackedPairs = []pairDef{
 	{"WETH/WBTC", eth.WETHAddress, eth.WETHDecimals, eth.WBTCAddress, eth.WBTCDecimals},
 }
 
-func NewRunner(client *eth.Client, dbPath string) (*Runner, error) {
+func NewRunner(client eth.Backend, dbPath string) (*Runner, error) {
 	db, err := NewMempoolDB(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("open mempool db: %w", err)
@@ -45,9 +48,21 @@ func NewRunner(client *eth.Client, dbPath string) (*Runner, error) {
 		mempoolDB: db,
 		gasPrice:  big.NewInt(30e9),   // 30 gwei
 		gasLimit:  big.NewInt(300000), // 300k gas
+		cacheDB:   simulator.DefaultCacheDBPath,
 	}, nil
 }
 
+// SetPending makes ProcessBlock detect on the reserves after the chosen
+// pending mempool txs instead of the bare N-1 state
+func (r *Runner) SetPending(cfg PendingConfig) {
+	r.pending = cfg
+}
+
+// SetCacheDBPath moves the forks' SQLite state cache
+func (r *Runner) SetCacheDBPath(path string) {
+	r.cacheDB = path
+}
+
 func (r *Runner) Close() error {
 	return r.mempoolDB.Close()
 }
@@ -64,7 +79,6 @@ func (r *Runner) RunBacktest(ctx context.Context, startBlock, endBlock uint64) (
 	startTime := time.Now()
 
 	for blockNum := startBlock; blockNum <= endBlock; blockNum++ {
-		time.Sleep(500 * time.Millisecond)
 		blockCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 		result, err := r.ProcessBlock(blockCtx, blockNum)
 		cancel()
@@ -90,16 +104,35 @@ func (r *Runner) RunBacktest(ctx context.Context, startBlock, endBlock uint64) (
 
 // runs detections for a single block
 func (r *Runner) ProcessBlock(ctx context.Context, blockNum uint64) (*BlockResult, error) {
-	_, err := simulator.NewStateFork(r.client, new(big.Int).SetUint64(blockNum-1))
-	if err != nil {
-		return nil, fmt.Errorf("fork state error at %d: %w", blockNum-1, err)
+	// pools are read from the chain at N-1, or from a fork of it with the
+	// pending txs applied on top
+	var backend eth.Backend = r.client
+	pendingTxs := 0
+	if r.pending.Enabled {
+		fork, err := simulator.NewStateForkWithCache(r.client, new(big.Int).SetUint64(blockNum-1), r.cacheDB)
+		if err != nil {
+			return nil, fmt.Errorf("fork state error at %d: %w", blockNum-1, err)
+		}
+		defer fork.Close()
+
+		header, err := r.client.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNum))
+		if err != nil {
+			return nil, fmt.Errorf("fetch header %d: %w", blockNum, err)
+		}
+		block := types.NewBlockWithHeader(header)
+
+		pendingTxs, err = r.applyPending(fork, block)
+		if err != nil {
+			return nil, fmt.Errorf("pending state error at %d: %w", blockNum, err)
+		}
+		backend = simulator.NewForkBackend(fork, block)
 	}
 
 	preMEV := new(big.Int).SetUint64(blockNum - 1)
 	predicted := make([]*arbitrage.Opportunity, 0)
 
 	for _, p := range trackedPairs {
-		pools, err := arbitrage.GetPairPools(ctx, r.client, preMEV,
+		pools, err := arbitrage.GetPairPools(ctx, backend, preMEV,
 			p.tokenA, p.tokenADec, p.tokenB, p.tokenBDec)
 		if err != nil {
 			// Not enough active pools for this pair — skip
@@ -121,7 +154,7 @@ func (r *Runner) ProcessBlock(ctx context.Context, blockNum uint64) (*BlockResul
 	// Log missed blocks with spread info across all pairs
 	if len(actual) > 0 && len(predicted) == 0 {
 		for _, p := range trackedPairs {
-			pools, err := arbitrage.GetPairPools(ctx, r.client, preMEV,
+			pools, err := arbitrage.GetPairPools(ctx, backend, preMEV,
 				p.tokenA, p.tokenADec, p.tokenB, p.tokenBDec)
 			if err != nil {
 				continue
@@ -139,5 +172,6 @@ func (r *Runner) ProcessBlock(ctx context.Context, blockNum uint64) (*BlockResul
 		BlockNumber: blockNum,
 		Predicted:   predicted,
 		Actual:      actual,
+		PendingTxs:  pendingTxs,
 	}, nil
 }
\ No newline at end of file
@@ -55,11 +55,21 @@ type BacktestMetrics struct {
 	AvgProfitError   float64
 }
 
+// PendingConfig picks the mempool txs applied on top of block N-1 before
+// detection, to see the reserves a live searcher would have seen just before
+// block N was built
+type PendingConfig struct {
+	Enabled bool
+	To      []common.Address // only txs sent to these (e.g. DEX routers); empty = any
+	MaxTxs  int              // highest gas price first, nonce order per sender; 0 = no limit
+}
+
 //  stores prediction vs actual for one block
 type BlockResult struct {
 	BlockNumber uint64
 	Predicted   []*arbitrage.Opportunity
 	Actual      []*ActualArbitrage
+	PendingTxs  int // pending txs applied before detection
 }
 
 // aggregates results across multiple blocks
@@ -75,6 +85,7 @@ type BacktestReport struct {
 	TruePositives    int  // blocks where we predicted AND actual arb existed
 	FalsePositives   int  // blocks where we predicted but no actual arb
 	FalseNegatives   int  // blocks where actual arb but we didn't predict
+	PendingTxs       int  // pending txs applied across all blocks
 }
 
 func (r *BacktestReport) CalculateMetrics() {
@@ -86,6 +97,7 @@ func (r *BacktestReport) CalculateMetrics() {
 
 		r.TotalPredicted += len(result.Predicted)
 		r.TotalActual += len(result.Actual)
+		r.PendingTxs += result.PendingTxs
 
 		if hasPredicted&&hasActual {
 			r.TruePositives++
@@ -105,6 +117,9 @@ func (r *BacktestReport) Print() {
 	fmt.Printf("\nOpportunities:\n")
 	fmt.Printf("  Predicted:            %d\n", r.TotalPredicted)
 	fmt.Printf("  Actual (ground truth):%d\n", r.TotalActual)
+	if r.PendingTxs > 0 {
+		fmt.Printf("  Pending txs applied:  %d\n", r.PendingTxs)
+	}
 	fmt.Printf("\nBlock-level accuracy:\n")
 	fmt.Printf("  True Positives:       %d (predicted + actual)\n", r.TruePositives)
 	fmt.Printf("  False Positives:      %d (predicted, no actual)\n", r.FalsePositives)
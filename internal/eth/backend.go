@@ -0,0 +1,32 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Backend is the RPC surface the simulator, detector and backtester depend on.
+// *Client satisfies it against a JSON-RPC endpoint; anything else (a local node,
+// a recorded fixture, an in-memory fake) can be swapped in by implementing it.
+type Backend interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+	StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	GetBlockReceipts(ctx context.Context, blockNum uint64) ([]*types.Receipt, error)
+	TraceTransaction(ctx context.Context, txHash common.Hash, blockNumber *big.Int) (*TraceResult, error)
+
+	// batched variants used for cache prewarming
+	BatchGetAccounts(ctx context.Context, requests []BatchAccountRequest) []BatchAccountResult
+	BatchGetStorage(ctx context.Context, requests []BatchStorageRequest) []BatchStorageResult
+}
+
+var _ Backend = (*Client)(nil)
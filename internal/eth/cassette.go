@@ -0,0 +1,321 @@
+package eth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cassettes record JSON-RPC traffic at the HTTP layer so every call the client
+// makes (ethclient methods, raw CallContext, BatchCallContext batches,
+// debug_traceTransaction) is captured without touching the individual methods.
+//
+// On disk a cassette is JSON lines, one unique (method, params) pair per line.
+// Request ids are stripped so recordings are independent of call order.
+
+// cassetteEntry is one recorded call
+type cassetteEntry struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func cassetteKey(method string, params json.RawMessage) string {
+	var buf bytes.Buffer
+	if len(params) > 0 && json.Compact(&buf, params) == nil {
+		return method + buf.String()
+	}
+	return method + string(params)
+}
+
+// parseMessages accepts either a single JSON-RPC message or a batch
+func parseMessages(body []byte) ([]*jsonrpcMessage, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var msgs []*jsonrpcMessage
+		if err := json.Unmarshal(body, &msgs); err != nil {
+			return nil, true, err
+		}
+		return msgs, true, nil
+	}
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, false, err
+	}
+	return []*jsonrpcMessage{&msg}, false, nil
+}
+
+func loadCassette(path string) (map[string]*cassetteEntry, error) {
+	entries := make(map[string]*cassetteEntry)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 256*1024*1024) // traces and blocks can be large
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e cassetteEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("cassette %s line %d: %w", path, line, err)
+		}
+		entries[cassetteKey(e.Method, e.Params)] = &e
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cassette %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// recordingTransport forwards requests to the real endpoint and appends every
+// new call/response pair to the cassette file
+type recordingTransport struct {
+	next http.RoundTripper
+
+	mu   sync.Mutex
+	seen map[string]bool
+	out  *os.File
+}
+
+func newRecordingTransport(path string, next http.RoundTripper) (*recordingTransport, error) {
+	seen := make(map[string]bool)
+
+	// Keep extending an existing cassette instead of clobbering it
+	existing, err := loadCassette(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for key := range existing {
+		seen[key] = true
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette dir: %w", err)
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open cassette: %w", err)
+	}
+
+	return &recordingTransport{next: next, seen: seen, out: out}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	// Only successful HTTP exchanges are worth replaying (429s etc. are not)
+	if resp.StatusCode == http.StatusOK {
+		t.record(reqBody, respBody)
+	}
+	return resp, nil
+}
+
+func (t *recordingTransport) record(reqBody, respBody []byte) {
+	calls, _, err := parseMessages(reqBody)
+	if err != nil {
+		return
+	}
+	answers, _, err := parseMessages(respBody)
+	if err != nil {
+		return
+	}
+
+	byID := make(map[string]*jsonrpcMessage, len(answers))
+	for _, a := range answers {
+		byID[string(a.ID)] = a
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.out == nil {
+		return // closed
+	}
+
+	for _, call := range calls {
+		answer, ok := byID[string(call.ID)]
+		if !ok {
+			continue
+		}
+		key := cassetteKey(call.Method, call.Params)
+		if t.seen[key] {
+			continue
+		}
+
+		line, err := json.Marshal(&cassetteEntry{
+			Method: call.Method,
+			Params: call.Params,
+			Result: answer.Result,
+			Error:  answer.Error,
+		})
+		if err != nil {
+			continue
+		}
+		if _, err := t.out.Write(append(line, '\n')); err != nil {
+			fmt.Printf("  [cassette] failed to record %s: %v\n", call.Method, err)
+			continue
+		}
+		t.seen[key] = true
+	}
+}
+
+// Close syncs and closes the cassette file; later calls aren't recorded
+func (t *recordingTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.out == nil {
+		return nil
+	}
+	err := t.out.Sync()
+	if cerr := t.out.Close(); err == nil {
+		err = cerr
+	}
+	t.out = nil
+	return err
+}
+
+// replayTransport answers every request from a cassette and never touches the network
+type replayTransport struct {
+	path    string
+	entries map[string]*cassetteEntry
+}
+
+func newReplayTransport(path string) (*replayTransport, error) {
+	entries, err := loadCassette(path)
+	if err != nil {
+		return nil, fmt.Errorf("load cassette: %w", err)
+	}
+	return &replayTransport{path: path, entries: entries}, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return nil, fmt.Errorf("cassette %s: empty request", t.path)
+	}
+	reqBody, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	calls, batch, err := parseMessages(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: malformed request: %w", t.path, err)
+	}
+
+	answers := make([]*jsonrpcMessage, len(calls))
+	for i, call := range calls {
+		entry, ok := t.entries[cassetteKey(call.Method, call.Params)]
+		if !ok {
+			return nil, fmt.Errorf("cassette %s: no recording for %s %s", t.path, call.Method, string(call.Params))
+		}
+		answers[i] = &jsonrpcMessage{
+			Version: "2.0",
+			ID:      call.ID,
+			Result:  entry.Result,
+			Error:   entry.Error,
+		}
+		// A recorded null result still has to be sent back as "result": null
+		if answers[i].Result == nil && answers[i].Error == nil {
+			answers[i].Result = json.RawMessage("null")
+		}
+	}
+
+	var respBody []byte
+	if batch {
+		respBody, err = json.Marshal(answers)
+	} else {
+		respBody, err = json.Marshal(answers[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// NewRecordingClient talks to url and saves every JSON-RPC exchange to the
+// cassette at path, appending to it if it already exists
+func NewRecordingClient(url, path string) (*Client, error) {
+	rt, err := newRecordingTransport(path, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dialWithTransport(url, rt)
+	if err != nil {
+		rt.Close()
+		return nil, err
+	}
+	c.recorder = rt
+	return c, nil
+}
+
+// NewReplayClient serves every call from the cassette at path. Any request
+// that was not recorded fails with an error naming the method and params.
+func NewReplayClient(path string) (*Client, error) {
+	rt, err := newReplayTransport(path)
+	if err != nil {
+		return nil, err
+	}
+	// The URL is never dialled, the transport answers everything
+	return dialWithTransport("http://cassette.invalid", rt)
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum"  
@@ -18,35 +21,143 @@ import (
 type Client struct {
 	rpc *ethclient.Client
 	rawRPC *rpc.Client
+
+	// set when the client load-balances over several endpoints
+	pool *poolTransport
+	// set when calls are recorded to a cassette
+	recorder *recordingTransport
 }
 
+// NewClient builds the project's RPC client from .env:
+//   - RPC_REPLAY: serve everything from a recorded cassette, no API key needed
+//   - RPC_URLS: comma-separated endpoints to load-balance over (falls back to ALCHEMY_URL)
+//   - RPC_RATE_LIMIT: requests per second per endpoint, unlimited if unset
+//   - RPC_RECORD: save every call to a cassette while talking to the endpoints
 func NewClient() (*Client, error) {
 	godotenv.Load()
-	url := os.Getenv("ALCHEMY_URL")
+
+	if path := os.Getenv("RPC_REPLAY"); path != "" {
+		return NewReplayClient(path)
+	}
+
+	urls := make([]string, 0)
+	for _, u := range strings.Split(os.Getenv("RPC_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		if url := os.Getenv("ALCHEMY_URL"); url != "" {
+			urls = append(urls, url)
+		}
+	}
 	
-	if url == "" {
+	if len(urls) == 0 {
 		return nil, fmt.Errorf("ALCHEMY_URL not set in .env")
 	}
-	
+
+	// the pool speaks HTTP only; a lone ws:// or ipc endpoint is dialed as is
+	if len(urls) == 1 && !isHTTP(urls[0]) {
+		if os.Getenv("RPC_RECORD") != "" {
+			return nil, fmt.Errorf("RPC_RECORD needs an http(s) endpoint")
+		}
+		return DialClient(urls[0])
+	}
+
+	opts := DefaultPoolOptions()
+	if v := os.Getenv("RPC_RATE_LIMIT"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RPC_RATE_LIMIT %q: %w", v, err)
+		}
+		opts.RateLimit = rate
+		opts.Burst = int(rate)
+	}
+
+	pool, err := newPoolTransport(urls, opts, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rt       http.RoundTripper = pool
+		recorder *recordingTransport
+	)
+	if path := os.Getenv("RPC_RECORD"); path != "" {
+		recorder, err = newRecordingTransport(path, pool)
+		if err != nil {
+			return nil, err
+		}
+		rt = recorder
+	}
+
+	c, err := dialWithTransport(urls[0], rt)
+	if err != nil {
+		if recorder != nil {
+			recorder.Close()
+		}
+		return nil, err
+	}
+	c.pool = pool
+	c.recorder = recorder
+	return c, nil
+}
+
+func isHTTP(raw string) bool {
+	raw = strings.ToLower(raw)
+	return strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://")
+}
+
+// DialClient connects to an arbitrary JSON-RPC endpoint (local node, archive
+// provider, test server) instead of the one configured in .env
+func DialClient(url string) (*Client, error) {
 	// Create raw RPC client first
 	rawRPCClient, err := rpc.Dial(url)
 	if err != nil {
 		return nil, err
 	}
-	
+
+	return wrapRPC(rawRPCClient), nil
+}
+
+// dialWithTransport routes all HTTP traffic of the client through rt
+func dialWithTransport(url string, rt http.RoundTripper) (*Client, error) {
+	rawRPCClient, err := rpc.DialHTTPWithClient(url, &http.Client{Transport: rt})
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapRPC(rawRPCClient), nil
+}
+
+func wrapRPC(rawRPCClient *rpc.Client) *Client {
 	// Wrap in ethclient
 	ethClient := ethclient.NewClient(rawRPCClient)
 	
 	return &Client{
 		rpc:    ethClient,
 		rawRPC: rawRPCClient,
-	}, nil
+	}
+}
+
+// Close shuts the connection down and flushes the cassette being recorded,
+// if any
+func (c *Client) Close() error {
+	c.rawRPC.Close()
+	if c.recorder != nil {
+		return c.recorder.Close()
+	}
+	return nil
 }
 
 func (c *Client) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
     return c.rpc.BlockByNumber(ctx, number)
 }
 
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return c.rpc.HeaderByNumber(ctx, number)
+}
+
 func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
     return c.rpc.BalanceAt(ctx, account, blockNumber)
 }
@@ -0,0 +1,434 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PoolOptions tunes the multi-endpoint transport
+type PoolOptions struct {
+	RateLimit      float64       // requests per second per endpoint, <= 0 disables limiting
+	Burst          int           // token bucket size per endpoint
+	MaxRetries     int           // extra attempts after the first one
+	Backoff        time.Duration // base delay, doubled on every retry
+	Cooldown       time.Duration // how long a failing endpoint is skipped
+	AttemptTimeout time.Duration // per-attempt deadline, 0 = only the caller's context
+}
+
+// DefaultPoolOptions doesn't rate limit; set RateLimit (RPC_RATE_LIMIT) to match
+// the provider's plan
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		RateLimit:      0,
+		Burst:          10,
+		MaxRetries:     5,
+		Backoff:        250 * time.Millisecond,
+		Cooldown:       5 * time.Second,
+		AttemptTimeout: 30 * time.Second,
+	}
+}
+
+// EndpointStats is a snapshot of one endpoint's counters
+type EndpointStats struct {
+	URL        string
+	Requests   int
+	Errors     int
+	Retries    int
+	AvgLatency time.Duration
+}
+
+// tokenBucket is a minimal rate limiter: rate tokens/s refilled up to burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// waitN blocks until n tokens are available (n is capped at the burst size)
+func (b *tokenBucket) waitN(ctx context.Context, n int) error {
+	if b.rate <= 0 {
+		return nil
+	}
+	need := float64(n)
+	if need > b.burst {
+		need = b.burst
+	}
+
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= need {
+			b.tokens -= need
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+type endpoint struct {
+	url     *url.URL
+	limiter *tokenBucket
+
+	mu             sync.Mutex
+	requests       int
+	errors         int
+	retries        int
+	totalLatency   time.Duration
+	unhealthyUntil time.Time
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !now.Before(e.unhealthyUntil)
+}
+
+func (e *endpoint) observe(latency time.Duration, failed bool, cooldown time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests++
+	e.totalLatency += latency
+	if failed {
+		e.errors++
+		e.unhealthyUntil = time.Now().Add(cooldown)
+	}
+}
+
+// redacted hides API keys that providers put in the path or query
+func (e *endpoint) redacted() string {
+	return e.url.Scheme + "://" + e.url.Host
+}
+
+// poolTransport spreads JSON-RPC requests over several endpoints, rate limits
+// each one, and retries transient failures (429, 5xx, rate limit errors in a
+// JSON-RPC body, network errors) on the next healthy endpoint with
+// exponential backoff
+type poolTransport struct {
+	endpoints []*endpoint
+	opts      PoolOptions
+	next      http.RoundTripper
+
+	mu     sync.Mutex
+	cursor int
+}
+
+func newPoolTransport(urls []string, opts PoolOptions, next http.RoundTripper) (*poolTransport, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no RPC endpoints configured")
+	}
+
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RPC url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("RPC pool only supports http(s) endpoints, got %s", u.Scheme)
+		}
+		endpoints = append(endpoints, &endpoint{
+			url:     u,
+			limiter: newTokenBucket(opts.RateLimit, opts.Burst),
+		})
+	}
+
+	return &poolTransport{endpoints: endpoints, opts: opts, next: next}, nil
+}
+
+// pick returns the next healthy endpoint in round-robin order, or the one that
+// recovers soonest if all of them are cooling down
+func (p *poolTransport) pick() *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for i := 0; i < len(p.endpoints); i++ {
+		e := p.endpoints[(p.cursor+i)%len(p.endpoints)]
+		if e.healthy(now) {
+			p.cursor = (p.cursor + i + 1) % len(p.endpoints)
+			return e
+		}
+	}
+
+	best := p.endpoints[0]
+	for _, e := range p.endpoints[1:] {
+		e.mu.Lock()
+		until := e.unhealthyUntil
+		e.mu.Unlock()
+		best.mu.Lock()
+		bestUntil := best.unhealthyUntil
+		best.mu.Unlock()
+		if until.Before(bestUntil) {
+			best = e
+		}
+	}
+	return best
+}
+
+func (p *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// batches cost one token per element
+	weight := 1
+	if msgs, batch, err := parseMessages(body); err == nil && batch {
+		weight = len(msgs)
+	}
+
+	ctx := req.Context()
+	var lastErr error
+
+	for attempt := 0; attempt <= p.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleepCtx(ctx, p.backoff(attempt)); err != nil {
+				return nil, err
+			}
+		}
+
+		e := p.pick()
+		if attempt > 0 {
+			e.mu.Lock()
+			e.retries++
+			e.mu.Unlock()
+		}
+		if err := e.limiter.waitN(ctx, weight); err != nil {
+			return nil, err
+		}
+
+		resp, retryAfter, retryable, err := p.try(ctx, req, e, body)
+		if err == nil {
+			return resp, nil
+		}
+		if !retryable {
+			return nil, err
+		}
+		lastErr = err
+
+		// the caller gave up, no point retrying
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if retryAfter > 0 {
+			if err := sleepCtx(ctx, retryAfter); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("all %d attempts failed: %w", p.opts.MaxRetries+1, lastErr)
+}
+
+// try performs one attempt against e. A nil error means resp is final (which
+// includes non-retryable HTTP errors like 400s).
+func (p *poolTransport) try(ctx context.Context, req *http.Request, e *endpoint, body []byte) (*http.Response, time.Duration, bool, error) {
+	attemptCtx := ctx
+	if p.opts.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, p.opts.AttemptTimeout)
+		defer cancel()
+	}
+
+	out := req.Clone(attemptCtx)
+	out.URL = e.url
+	out.Host = ""
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+
+	start := time.Now()
+	resp, err := p.next.RoundTrip(out)
+	if err != nil {
+		e.observe(time.Since(start), true, p.opts.Cooldown)
+		retryable := isTransient(err) || attemptCtx.Err() != nil
+		return nil, 0, retryable, fmt.Errorf("%s: %w", e.redacted(), err)
+	}
+
+	// read the whole body now, the attempt context dies when we return
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+	if err != nil {
+		e.observe(latency, true, p.opts.Cooldown)
+		return nil, 0, true, fmt.Errorf("%s: read response: %w", e.redacted(), err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		cooldown := p.opts.Cooldown
+		if retryAfter > cooldown {
+			cooldown = retryAfter
+		}
+		e.observe(latency, true, cooldown)
+		return nil, retryAfter, true, fmt.Errorf("%s: http %d", e.redacted(), resp.StatusCode)
+	}
+	// some providers throttle with a 200 and a JSON-RPC error instead
+	if code, ok := rateLimitError(respBody); ok {
+		e.observe(latency, true, p.opts.Cooldown)
+		return nil, 0, true, fmt.Errorf("%s: rate limited (json-rpc error %d)", e.redacted(), code)
+	}
+
+	e.observe(latency, false, 0)
+	return resp, 0, false, nil
+}
+
+func (p *poolTransport) backoff(attempt int) time.Duration {
+	d := p.opts.Backoff << (attempt - 1)
+	if d <= 0 || d > 10*time.Second {
+		d = 10 * time.Second
+	}
+	// +-20% jitter so parallel callers don't retry in lockstep
+	jitter := time.Duration(rand.Int63n(int64(d)/5+1)) - d/10
+	return d + jitter
+}
+
+func (p *poolTransport) stats() []EndpointStats {
+	out := make([]EndpointStats, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		s := EndpointStats{
+			URL:      e.redacted(),
+			Requests: e.requests,
+			Errors:   e.errors,
+			Retries:  e.retries,
+		}
+		if e.requests > 0 {
+			s.AvgLatency = e.totalLatency / time.Duration(e.requests)
+		}
+		e.mu.Unlock()
+		out = append(out, s)
+	}
+	return out
+}
+
+// rateLimitErrorCodes are the JSON-RPC error codes providers use for
+// throttling: 429 (Alchemy, QuickNode) and -32005 (Infura, "limit exceeded")
+var rateLimitErrorCodes = map[int]bool{429: true, -32005: true}
+
+// rateLimitError reports whether a response body (single or batch) carries a
+// rate limit error
+func rateLimitError(body []byte) (int, bool) {
+	msgs, _, err := parseMessages(body)
+	if err != nil {
+		return 0, false
+	}
+	for _, msg := range msgs {
+		if msg != nil && msg.Error != nil && rateLimitErrorCodes[msg.Error.Code] {
+			return msg.Error.Code, true
+		}
+	}
+	return 0, false
+}
+
+func isTransient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewPooledClient load-balances over urls with per-endpoint rate limits,
+// retries and failover
+func NewPooledClient(urls []string, opts PoolOptions) (*Client, error) {
+	pool, err := newPoolTransport(urls, opts, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	c, err := dialWithTransport(urls[0], pool)
+	if err != nil {
+		return nil, err
+	}
+	c.pool = pool
+	return c, nil
+}
+
+// EndpointStats reports per-endpoint counters, empty for unpooled clients
+func (c *Client) EndpointStats() []EndpointStats {
+	if c.pool == nil {
+		return nil
+	}
+	return c.pool.stats()
+}
+
+func (c *Client) PrintEndpointStats() {
+	stats := c.EndpointStats()
+	if len(stats) == 0 {
+		return
+	}
+
+	fmt.Printf("\n=== RPC Endpoints ===\n")
+	for _, s := range stats {
+		fmt.Printf("%-40s requests: %6d  errors: %4d  retries: %4d  avg latency: %s\n",
+			s.URL, s.Requests, s.Errors, s.Retries, s.AvgLatency.Round(time.Millisecond))
+	}
+	fmt.Println()
+}
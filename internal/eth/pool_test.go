@@ -0,0 +1,140 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func testPoolOptions() PoolOptions {
+	opts := DefaultPoolOptions()
+	opts.RateLimit = 0
+	opts.Backoff = time.Millisecond
+	opts.Cooldown = time.Minute
+	return opts
+}
+
+func TestPoolFailsOverAfterRateLimit(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &fakeEthAPI{}); err != nil {
+		t.Fatalf("register api: %v", err)
+	}
+	healthy := httptest.NewServer(server)
+	defer healthy.Close()
+
+	var throttled atomic.Int32
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		throttled.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+
+	client, err := NewPooledClient([]string{limited.URL, healthy.URL}, testPoolOptions())
+	if err != nil {
+		t.Fatalf("NewPooledClient: %v", err)
+	}
+
+	addr := common.HexToAddress("0x0000000100000000000000000000000000000000")
+	for i := 0; i < 3; i++ {
+		bal, err := client.BalanceAt(context.Background(), addr, big.NewInt(1))
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if bal.Int64() != 1 {
+			t.Fatalf("call %d: balance %s, want 1", i, bal)
+		}
+	}
+
+	// the throttled endpoint is benched after its first 429
+	if n := throttled.Load(); n != 1 {
+		t.Errorf("throttled endpoint hit %d times, want 1", n)
+	}
+
+	stats := client.EndpointStats()
+	if stats[0].Errors != 1 || stats[1].Requests != 3 || stats[1].Retries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPoolRetriesJSONRPCRateLimit(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &fakeEthAPI{}); err != nil {
+		t.Fatalf("register api: %v", err)
+	}
+	healthy := httptest.NewServer(server)
+	defer healthy.Close()
+
+	// Infura-style throttling: http 200 with a -32005 error body
+	var throttled atomic.Int32
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		throttled.Add(1)
+		var req jsonrpcMessage
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(&jsonrpcMessage{
+			Version: "2.0", ID: req.ID, Error: &jsonrpcError{Code: -32005, Message: "limit exceeded"},
+		})
+	}))
+	defer limited.Close()
+
+	client, err := NewPooledClient([]string{limited.URL, healthy.URL}, testPoolOptions())
+	if err != nil {
+		t.Fatalf("NewPooledClient: %v", err)
+	}
+	addr := common.HexToAddress("0x0000000100000000000000000000000000000000")
+	if bal, err := client.BalanceAt(context.Background(), addr, big.NewInt(1)); err != nil || bal.Int64() != 1 {
+		t.Fatalf("BalanceAt = %v, %v", bal, err)
+	}
+	if n := throttled.Load(); n != 1 {
+		t.Errorf("throttled endpoint hit %d times, want 1", n)
+	}
+	if stats := client.EndpointStats(); stats[0].Errors != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPoolGivesUpAfterMaxRetries(t *testing.T) {
+	var hits atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	opts := testPoolOptions()
+	opts.MaxRetries = 2
+	client, err := NewPooledClient([]string{down.URL}, opts)
+	if err != nil {
+		t.Fatalf("NewPooledClient: %v", err)
+	}
+
+	if _, err := client.BalanceAt(context.Background(), common.Address{}, nil); err == nil {
+		t.Fatal("expected error from a dead endpoint")
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("endpoint hit %d times, want 3", n)
+	}
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	b := newTokenBucket(100, 1)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := b.waitN(ctx, 1); err != nil {
+			t.Fatalf("waitN: %v", err)
+		}
+	}
+	// first token is free, the other five take ~10ms each
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("6 tokens at 100/s took %s, limiter not applied", elapsed)
+	}
+}
@@ -0,0 +1,327 @@
+package forkserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+// revertError mirrors geth's eth_call revert error (code 3 + return data)
+type revertError struct {
+	reason string
+	data   hexutil.Bytes
+}
+
+func (e *revertError) Error() string          { return e.reason }
+func (e *revertError) ErrorCode() int         { return 3 }
+func (e *revertError) ErrorData() interface{} { return e.data }
+
+// ethAPI implements the eth_ namespace
+type ethAPI struct {
+	s *Server
+}
+
+// checkBlock only lets through tags and numbers that mean the current state;
+// history before the fork, or before the last mined block, isn't kept
+func (api *ethAPI) checkBlock(number *rpc.BlockNumberOrHash) error {
+	if number == nil {
+		return nil
+	}
+	if hash, ok := number.Hash(); ok {
+		if hash == api.s.head().Hash() {
+			return nil
+		}
+		return fmt.Errorf("state of block %s is not available, only the latest", hash.Hex())
+	}
+	num, _ := number.Number()
+	if num < 0 || uint64(num) == api.s.head().NumberU64() {
+		return nil
+	}
+	return fmt.Errorf("state of block %d is not available, only the latest (%d)", num, api.s.head().NumberU64())
+}
+
+func (api *ethAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(api.s.config.ChainID)
+}
+
+func (api *ethAPI) BlockNumber() hexutil.Uint64 {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	return hexutil.Uint64(api.s.head().NumberU64())
+}
+
+func (api *ethAPI) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	block := api.s.head()
+	if number >= 0 {
+		first := api.s.blocks[0].NumberU64()
+		if uint64(number) < first || uint64(number) > block.NumberU64() {
+			return nil, nil // JSON null, ethclient turns it into ethereum.NotFound
+		}
+		block = api.s.blocks[uint64(number)-first]
+	}
+	return marshalBlock(block, fullTx)
+}
+
+func (api *ethAPI) GetBalance(addr common.Address, number *rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if err := api.checkBlock(number); err != nil {
+		return nil, err
+	}
+	bal, err := api.s.fork.GetBalance(addr)
+	return (*hexutil.Big)(bal), err
+}
+
+func (api *ethAPI) GetTransactionCount(addr common.Address, number *rpc.BlockNumberOrHash) (hexutil.Uint64, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if err := api.checkBlock(number); err != nil {
+		return 0, err
+	}
+	nonce, err := api.s.fork.GetNonce(addr)
+	return hexutil.Uint64(nonce), err
+}
+
+func (api *ethAPI) GetCode(addr common.Address, number *rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if err := api.checkBlock(number); err != nil {
+		return nil, err
+	}
+	code, err := api.s.fork.GetCode(addr)
+	return common.CopyBytes(code), err
+}
+
+// GetStorageAt takes the slot as any hex string up to 32 bytes, padded or not
+func (api *ethAPI) GetStorageAt(addr common.Address, key string, number *rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	slot, err := decodeSlot(key)
+	if err != nil {
+		return nil, err
+	}
+
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if err := api.checkBlock(number); err != nil {
+		return nil, err
+	}
+	val, err := api.s.fork.GetStorageAt(addr, slot)
+	return val.Bytes(), err
+}
+
+func decodeSlot(key string) (common.Hash, error) {
+	hex := strings.TrimPrefix(strings.TrimPrefix(key, "0x"), "0X")
+	if len(hex)%2 == 1 {
+		hex = "0" + hex
+	}
+	b, err := hexutil.Decode("0x" + hex)
+	if err != nil || len(b) > common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid storage slot %q", key)
+	}
+	return common.BytesToHash(b), nil
+}
+
+// Call runs the call on the current state and throws its changes away
+func (api *ethAPI) Call(args simulator.CallArgs, number *rpc.BlockNumberOrHash, overrides *simulator.StateOverride) (hexutil.Bytes, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if err := api.checkBlock(number); err != nil {
+		return nil, err
+	}
+
+	snap := api.s.fork.Snapshot()
+	defer api.s.fork.RevertToSnapshot(snap)
+	if overrides != nil {
+		if err := api.s.fork.ApplyOverrides(*overrides); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := api.s.executor.ExecuteCall(args.ToCallMsg(), api.s.head())
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		if result.EffectiveGasPrice != nil && len(result.ReturnData) > 0 {
+			return nil, &revertError{reason: result.RevertReason, data: result.ReturnData}
+		}
+		return nil, fmt.Errorf("%s", result.RevertReason)
+	}
+	return result.ReturnData, nil
+}
+
+func (api *ethAPI) EstimateGas(args simulator.CallArgs, number *rpc.BlockNumberOrHash, overrides *simulator.StateOverride) (hexutil.Uint64, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if err := api.checkBlock(number); err != nil {
+		return 0, err
+	}
+
+	snap := api.s.fork.Snapshot()
+	defer api.s.fork.RevertToSnapshot(snap)
+	if overrides != nil {
+		if err := api.s.fork.ApplyOverrides(*overrides); err != nil {
+			return 0, err
+		}
+	}
+
+	gas, err := api.s.executor.EstimateGas(args.ToCallMsg(), api.s.head())
+	return hexutil.Uint64(gas), err
+}
+
+// SendRawTransaction mines the tx into a new block right away. Txs signed for
+// another chain are rejected; unprotected legacy txs are let through.
+func (api *ethAPI) SendRawTransaction(input hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	if tx.Protected() && tx.ChainId().Cmp(api.s.config.ChainID) != 0 {
+		return common.Hash{}, fmt.Errorf("invalid chain id %s, want %s", tx.ChainId(), api.s.config.ChainID)
+	}
+
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if _, ok := api.s.receipts[tx.Hash()]; ok {
+		return common.Hash{}, fmt.Errorf("already known")
+	}
+	if _, err := api.s.mine(tx, 0); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+func (api *ethAPI) GetTransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	return api.s.receipts[hash], nil
+}
+
+func (api *ethAPI) GetTransactionByHash(hash common.Hash) (map[string]interface{}, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	tx, ok := api.s.txs[hash]
+	if !ok {
+		return nil, nil
+	}
+	receipt := api.s.receipts[hash]
+	block := api.s.blocks[receipt.BlockNumber.Uint64()-api.s.blocks[0].NumberU64()]
+	return marshalTx(tx, block, 0)
+}
+
+// evmAPI implements the Anvil/Hardhat evm_ extras
+type evmAPI struct {
+	s *Server
+}
+
+// Snapshot saves the current state; Revert goes back to it
+func (api *evmAPI) Snapshot() hexutil.Uint64 {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	api.s.snapshots = append(api.s.snapshots, snapshot{
+		forkID: api.s.fork.Snapshot(),
+		blocks: len(api.s.blocks),
+	})
+	return hexutil.Uint64(len(api.s.snapshots) - 1)
+}
+
+// Revert restores snapshot id, dropping blocks mined since. It and every
+// later snapshot are used up. Returns false for an unknown id.
+func (api *evmAPI) Revert(id hexutil.Uint64) (bool, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	if uint64(id) >= uint64(len(api.s.snapshots)) {
+		return false, nil
+	}
+	snap := api.s.snapshots[id]
+	if err := api.s.fork.RevertToSnapshot(snap.forkID); err != nil {
+		return false, err
+	}
+	for _, block := range api.s.blocks[snap.blocks:] {
+		for _, tx := range block.Transactions() {
+			delete(api.s.receipts, tx.Hash())
+			delete(api.s.txs, tx.Hash())
+		}
+	}
+	api.s.blocks = api.s.blocks[:snap.blocks]
+	api.s.snapshots = api.s.snapshots[:id]
+	return true, nil
+}
+
+// Mine adds an empty block, at timestamp if one is given
+func (api *evmAPI) Mine(timestamp *hexutil.Uint64) (string, error) {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	var ts uint64
+	if timestamp != nil {
+		ts = uint64(*timestamp)
+	}
+	if _, err := api.s.mine(nil, ts); err != nil {
+		return "", err
+	}
+	return "0x0", nil
+}
+
+// marshalBlock renders a block the way eth_getBlockByNumber does
+func marshalBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	headerJSON, err := json.Marshal(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(headerJSON, &fields); err != nil {
+		return nil, err
+	}
+
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+		rpcTx, err := marshalTx(tx, block, i)
+		if err != nil {
+			return nil, err
+		}
+		txs[i] = rpcTx
+	}
+
+	fields["hash"] = block.Hash()
+	fields["transactions"] = txs
+	fields["uncles"] = []common.Hash{}
+	fields["size"] = hexutil.Uint64(block.Size())
+	if block.Withdrawals() != nil {
+		fields["withdrawals"] = block.Withdrawals()
+	}
+	return fields, nil
+}
+
+func marshalTx(tx *types.Transaction, block *types.Block, index int) (map[string]interface{}, error) {
+	txJSON, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(txJSON, &fields); err != nil {
+		return nil, err
+	}
+	if from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+		fields["from"] = from
+	}
+	fields["blockHash"] = block.Hash()
+	fields["blockNumber"] = hexutil.Uint64(block.NumberU64())
+	fields["transactionIndex"] = hexutil.Uint64(index)
+	return fields, nil
+}
@@ -0,0 +1,158 @@
+package forkserver
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+// callBundleArgs is the Flashbots eth_callBundle request. The block fields
+// default to the block that would be mined next.
+type callBundleArgs struct {
+	Txs              []hexutil.Bytes  `json:"txs"`
+	BlockNumber      rpc.BlockNumber  `json:"blockNumber"`
+	StateBlockNumber *rpc.BlockNumber `json:"stateBlockNumber"`
+	Timestamp        *uint64          `json:"timestamp"`
+	Coinbase         *common.Address  `json:"coinbase"`
+	GasLimit         *uint64          `json:"gasLimit"`
+	BaseFee          *big.Int         `json:"baseFee"`
+}
+
+// callBundleResult is the eth_callBundle response; wei amounts are decimal
+// strings, as the relay returns them
+type callBundleResult struct {
+	BundleGasPrice    string         `json:"bundleGasPrice"`
+	BundleHash        common.Hash    `json:"bundleHash"`
+	CoinbaseDiff      string         `json:"coinbaseDiff"`
+	EthSentToCoinbase string         `json:"ethSentToCoinbase"`
+	GasFees           string         `json:"gasFees"`
+	Results           []callBundleTx `json:"results"`
+	StateBlockNumber  uint64         `json:"stateBlockNumber"`
+	TotalGasUsed      uint64         `json:"totalGasUsed"`
+}
+
+// callBundleTx is one tx of the response. Value is set when it succeeded,
+// Error (and Revert, the decoded reason) when it didn't.
+type callBundleTx struct {
+	CoinbaseDiff      string          `json:"coinbaseDiff"`
+	EthSentToCoinbase string          `json:"ethSentToCoinbase"`
+	FromAddress       common.Address  `json:"fromAddress"`
+	GasFees           string          `json:"gasFees"`
+	GasPrice          string          `json:"gasPrice"`
+	GasUsed           uint64          `json:"gasUsed"`
+	ToAddress         *common.Address `json:"toAddress"`
+	TxHash            common.Hash     `json:"txHash"`
+	Value             *hexutil.Bytes  `json:"value,omitempty"`
+	Error             string          `json:"error,omitempty"`
+	Revert            string          `json:"revert,omitempty"`
+}
+
+// CallBundle simulates a bundle on top of the latest state the way the
+// Flashbots relay does: txs run in order, a reverted one is reported without
+// stopping the rest, and nothing is kept afterwards
+func (api *ethAPI) CallBundle(args callBundleArgs) (*callBundleResult, error) {
+	if len(args.Txs) == 0 {
+		return nil, fmt.Errorf("bundle missing txs")
+	}
+	txs := make([]*types.Transaction, len(args.Txs))
+	for i, raw := range args.Txs {
+		txs[i] = new(types.Transaction)
+		if err := txs[i].UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("bundle tx %d: %w", i, err)
+		}
+	}
+
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+	if args.StateBlockNumber != nil {
+		state := rpc.BlockNumberOrHashWithNumber(*args.StateBlockNumber)
+		if err := api.checkBlock(&state); err != nil {
+			return nil, err
+		}
+	}
+
+	var timestamp uint64
+	if args.Timestamp != nil {
+		timestamp = *args.Timestamp
+	}
+	header := api.s.nextHeader(timestamp)
+	if args.BlockNumber > 0 {
+		header.Number = big.NewInt(args.BlockNumber.Int64())
+	}
+	if args.Coinbase != nil {
+		header.Coinbase = *args.Coinbase
+	}
+	if args.GasLimit != nil {
+		header.GasLimit = *args.GasLimit
+	}
+	if args.BaseFee != nil {
+		header.BaseFee = args.BaseFee
+	}
+
+	bundle, err := api.s.bundles.CallBundle(txs, types.NewBlockWithHeader(header))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &callBundleResult{
+		BundleGasPrice:    "0",
+		CoinbaseDiff:      bundle.CoinbaseDiff.String(),
+		EthSentToCoinbase: bundle.EthSentToCoinbase().String(),
+		GasFees:           bundle.GasFees.String(),
+		Results:           make([]callBundleTx, len(txs)),
+		StateBlockNumber:  api.s.head().NumberU64(),
+		TotalGasUsed:      bundle.TotalGasUsed,
+	}
+	if bundle.TotalGasUsed > 0 {
+		ret.BundleGasPrice = perGas(bundle.CoinbaseDiff, bundle.TotalGasUsed).String()
+	}
+
+	hashes := make([]byte, 0, len(txs)*common.HashLength)
+	for i, tx := range txs {
+		r := bundle.Transactions[i]
+		hashes = append(hashes, tx.Hash().Bytes()...)
+		from, _ := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+
+		res := callBundleTx{
+			CoinbaseDiff:      r.CoinbaseDiff.String(),
+			EthSentToCoinbase: new(big.Int).Sub(r.CoinbaseDiff, r.GasFees).String(),
+			FromAddress:       from,
+			GasFees:           r.GasFees.String(),
+			GasPrice:          "0",
+			GasUsed:           r.GasUsed,
+			ToAddress:         tx.To(),
+			TxHash:            r.TxHash,
+		}
+		// what the coinbase got per gas, direct payments included
+		if r.GasUsed > 0 {
+			res.GasPrice = perGas(r.CoinbaseDiff, r.GasUsed).String()
+		}
+		switch {
+		case r.Success:
+			value := hexutil.Bytes(common.CopyBytes(r.ReturnData))
+			res.Value = &value
+		case errors.Is(r.Err, vm.ErrExecutionReverted):
+			res.Error = vm.ErrExecutionReverted.Error()
+			if len(r.ReturnData) > 0 {
+				res.Revert = simulator.DecodeRevert(r.ReturnData)
+			}
+		default:
+			res.Error = r.RevertReason
+		}
+		ret.Results[i] = res
+	}
+	ret.BundleHash = crypto.Keccak256Hash(hashes)
+	return ret, nil
+}
+
+func perGas(amount *big.Int, gas uint64) *big.Int {
+	return new(big.Int).Div(amount, new(big.Int).SetUint64(gas))
+}
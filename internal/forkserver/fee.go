@@ -0,0 +1,119 @@
+package forkserver
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip1559"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// suggestedTip is the priority fee the server suggests, like Anvil's default;
+// every tx that pays the base fee gets mined anyway
+var suggestedTip = big.NewInt(1e9)
+
+// feeHistoryResult is the eth_feeHistory response
+type feeHistoryResult struct {
+	OldestBlock  *hexutil.Big     `json:"oldestBlock"`
+	Reward       [][]*hexutil.Big `json:"reward,omitempty"`
+	BaseFee      []*hexutil.Big   `json:"baseFeePerGas,omitempty"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+// GasPrice is the next block's base fee plus the suggested tip
+func (api *ethAPI) GasPrice() *hexutil.Big {
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	price := new(big.Int).Set(suggestedTip)
+	if baseFee := api.s.nextHeader(0).BaseFee; baseFee != nil {
+		price.Add(price, baseFee)
+	}
+	return (*hexutil.Big)(price)
+}
+
+func (api *ethAPI) MaxPriorityFeePerGas() *hexutil.Big {
+	return (*hexutil.Big)(new(big.Int).Set(suggestedTip))
+}
+
+// FeeHistory covers the forked block and the ones mined on top of it. Rewards
+// are the tips of each block's txs at the given percentiles, unweighted.
+func (api *ethAPI) FeeHistory(blockCount math.HexOrDecimal64, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*feeHistoryResult, error) {
+	for i, p := range rewardPercentiles {
+		if p < 0 || p > 100 || (i > 0 && p < rewardPercentiles[i-1]) {
+			return nil, fmt.Errorf("invalid reward percentile %f", p)
+		}
+	}
+
+	api.s.mu.Lock()
+	defer api.s.mu.Unlock()
+
+	first := api.s.blocks[0].NumberU64()
+	last := api.s.head().NumberU64()
+	if lastBlock >= 0 {
+		if uint64(lastBlock) < first || uint64(lastBlock) > last {
+			return nil, fmt.Errorf("block %d is not available, only %d to %d", lastBlock, first, last)
+		}
+		last = uint64(lastBlock)
+	}
+	count := uint64(blockCount)
+	if count > last-first+1 {
+		count = last - first + 1
+	}
+
+	result := &feeHistoryResult{OldestBlock: (*hexutil.Big)(new(big.Int).SetUint64(last - count + 1))}
+	if count == 0 {
+		return result, nil
+	}
+	for _, block := range api.s.blocks[last-count+1-first : last-first+1] {
+		header := block.Header()
+		baseFee := header.BaseFee
+		if baseFee == nil {
+			baseFee = new(big.Int)
+		}
+		result.BaseFee = append(result.BaseFee, (*hexutil.Big)(baseFee))
+		result.GasUsedRatio = append(result.GasUsedRatio, float64(header.GasUsed)/float64(header.GasLimit))
+
+		if len(rewardPercentiles) == 0 {
+			continue
+		}
+		var tips []*big.Int
+		for _, tx := range block.Transactions() {
+			if tip, err := tx.EffectiveGasTip(header.BaseFee); err == nil {
+				tips = append(tips, tip)
+			}
+		}
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		reward := make([]*hexutil.Big, len(rewardPercentiles))
+		for i, p := range rewardPercentiles {
+			tip := new(big.Int)
+			if len(tips) > 0 {
+				tip = tips[int(float64(len(tips)-1)*p/100)]
+			}
+			reward[i] = (*hexutil.Big)(tip)
+		}
+		result.Reward = append(result.Reward, reward)
+	}
+
+	// plus the base fee of the block after the last one
+	lastHeader := api.s.blocks[last-first].Header()
+	next := new(big.Int)
+	if api.s.config.IsLondon(new(big.Int).Add(lastHeader.Number, big.NewInt(1))) {
+		next = eip1559.CalcBaseFee(api.s.config, lastHeader)
+	}
+	result.BaseFee = append(result.BaseFee, (*hexutil.Big)(next))
+	return result, nil
+}
+
+// netAPI implements the net_ namespace
+type netAPI struct {
+	s *Server
+}
+
+// Version is the network id, which for the chains served here is the chain id
+func (api *netAPI) Version() string {
+	return api.s.config.ChainID.String()
+}
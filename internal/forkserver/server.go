@@ -0,0 +1,187 @@
+package forkserver
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip1559"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+)
+
+// blockTime is how far apart mined blocks are, in seconds
+const blockTime = 12
+
+// Server serves a StateFork over JSON-RPC, like a local Anvil/Hardhat node.
+// Every eth_sendRawTransaction is mined right away into its own block on top
+// of the fork; evm_mine adds an empty one. Reads and calls see the state
+// after the last mined block. Requests are handled one at a time.
+type Server struct {
+	mu       sync.Mutex
+	fork     *simulator.StateFork
+	executor *simulator.Executor
+	bundles  *simulator.BundleSimulator
+	config   *params.ChainConfig
+
+	// the forked block followed by everything mined locally
+	blocks   []*types.Block
+	receipts map[common.Hash]*types.Receipt
+	txs      map[common.Hash]*types.Transaction
+
+	snapshots []snapshot
+
+	rpc *rpc.Server
+}
+
+// snapshot is an evm_snapshot: the fork's journal position and chain length
+type snapshot struct {
+	forkID int
+	blocks int
+}
+
+// New wraps fork in a JSON-RPC server. Serve it with ServeHTTP.
+func New(fork *simulator.StateFork) (*Server, error) {
+	s := &Server{
+		fork:     fork,
+		executor: simulator.NewExecutor(fork),
+		bundles:  simulator.NewBundleSimulator(fork),
+		config:   params.MainnetChainConfig,
+		blocks:   []*types.Block{fork.BlockContext()},
+		receipts: make(map[common.Hash]*types.Receipt),
+		txs:      make(map[common.Hash]*types.Transaction),
+		rpc:      rpc.NewServer(),
+	}
+	if err := s.rpc.RegisterName("eth", &ethAPI{s}); err != nil {
+		return nil, fmt.Errorf("failed to register eth api: %w", err)
+	}
+	if err := s.rpc.RegisterName("evm", &evmAPI{s}); err != nil {
+		return nil, fmt.Errorf("failed to register evm api: %w", err)
+	}
+	if err := s.rpc.RegisterName("net", &netAPI{s}); err != nil {
+		return nil, fmt.Errorf("failed to register net api: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.rpc.ServeHTTP(w, r)
+}
+
+func (s *Server) Stop() {
+	s.rpc.Stop()
+}
+
+func (s *Server) head() *types.Block {
+	return s.blocks[len(s.blocks)-1]
+}
+
+// nextHeader is the header of the block the next mined txs go into
+func (s *Server) nextHeader(timestamp uint64) *types.Header {
+	parent := s.head().Header()
+	if timestamp <= parent.Time {
+		timestamp = parent.Time + blockTime
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Coinbase:   parent.Coinbase,
+		Number:     new(big.Int).Add(parent.Number, big.NewInt(1)),
+		GasLimit:   parent.GasLimit,
+		Time:       timestamp,
+		Difficulty: new(big.Int),
+		MixDigest:  parent.MixDigest,
+		UncleHash:  types.EmptyUncleHash,
+	}
+	if s.config.IsLondon(header.Number) {
+		header.BaseFee = eip1559.CalcBaseFee(s.config, parent)
+	}
+	if parent.ExcessBlobGas != nil {
+		excess := eip4844.CalcExcessBlobGas(s.config, parent, header.Time)
+		header.ExcessBlobGas = &excess
+		header.BlobGasUsed = new(uint64)
+	}
+	return header
+}
+
+// mine appends a block holding tx, or an empty one if tx is nil. A tx that
+// can't be included (bad nonce, fee cap below base fee, ...) is rejected and
+// leaves the state untouched.
+func (s *Server) mine(tx *types.Transaction, timestamp uint64) (*types.Block, error) {
+	header := s.nextHeader(timestamp)
+
+	var (
+		txs      []*types.Transaction
+		receipts []*types.Receipt
+	)
+	if tx != nil {
+		result, err := s.executor.ExecuteTransaction(tx, types.NewBlockWithHeader(header))
+		if err != nil {
+			return nil, err
+		}
+		// fees are only unset when the tx wasn't included at all
+		if result.EffectiveGasPrice == nil {
+			return nil, fmt.Errorf("tx rejected: %s", result.RevertReason)
+		}
+		header.GasUsed = result.GasUsed
+		if header.BlobGasUsed != nil {
+			*header.BlobGasUsed = result.BlobGasUsed
+		}
+		txs = []*types.Transaction{tx}
+		receipts = []*types.Receipt{newReceipt(tx, result)}
+	}
+
+	header.TxHash = types.DeriveSha(types.Transactions(txs), trie.NewStackTrie(nil))
+	header.ReceiptHash = types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil))
+	header.Bloom = types.MergeBloom(receipts)
+	block := types.NewBlockWithHeader(header).WithBody(types.Body{Transactions: txs})
+
+	// receipts and logs point at the final block
+	for _, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.Number()
+		for _, l := range receipt.Logs {
+			l.BlockHash, l.BlockNumber = block.Hash(), block.NumberU64()
+		}
+		s.receipts[tx.Hash()] = receipt
+		s.txs[tx.Hash()] = tx
+	}
+	s.blocks = append(s.blocks, block)
+	s.fork.SetBlockHash(block.NumberU64(), block.Hash())
+	return block, nil
+}
+
+// newReceipt is the receipt of tx as the only tx of its block
+func newReceipt(tx *types.Transaction, result *simulator.SimulationResult) *types.Receipt {
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		CumulativeGasUsed: result.GasUsed,
+		TxHash:            tx.Hash(),
+		GasUsed:           result.GasUsed,
+		EffectiveGasPrice: result.EffectiveGasPrice,
+		BlobGasUsed:       result.BlobGasUsed,
+		Logs:              result.Logs,
+	}
+	if receipt.Logs == nil {
+		receipt.Logs = []*types.Log{}
+	}
+	if result.Success {
+		receipt.Status = types.ReceiptStatusSuccessful
+	}
+	for i, l := range receipt.Logs {
+		l.TxHash, l.Index = tx.Hash(), uint(i)
+	}
+	if tx.To() == nil {
+		if from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+			receipt.ContractAddress = crypto.CreateAddress(from, tx.Nonce())
+		}
+	}
+	receipt.Bloom = types.CreateBloom(receipt)
+	return receipt
+}
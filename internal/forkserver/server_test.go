@@ -0,0 +1,237 @@
+package forkserver
+
+import (
+	"context"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+	"github.com/pulkyeet/mev-searcher/internal/simulator"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+const testBlock = 18_000_000
+
+func TestForkServer(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	counter := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	payer := common.HexToAddress("0x6666666666666666666666666666666666666666")
+	reverter := common.HexToAddress("0x7777777777777777777777777777777777777777")
+
+	node := testnode.New()
+	defer node.Close()
+	node.SetBalance(testBlock, sender, big.NewInt(1e18))
+	// sstore(0, add(sload(0), 1)); return sload(0)
+	node.SetCode(testBlock, counter, common.FromHex("60005460010160005560005460005260206000f3"))
+	// call(gas, coinbase, callvalue, 0, 0, 0, 0)
+	node.SetCode(testBlock, payer, common.FromHex("600060006000600034415af100"))
+	node.SetCode(testBlock, reverter, common.FromHex("60006000fd"))
+	node.AddBlock(testBlock, nil, nil)
+
+	backend, err := eth.DialClient(node.URL())
+	if err != nil {
+		t.Fatalf("dial node: %v", err)
+	}
+	fork, err := simulator.NewStateForkWithCache(backend, big.NewInt(testBlock), filepath.Join(t.TempDir(), "state_cache.db"))
+	if err != nil {
+		t.Fatalf("NewStateFork: %v", err)
+	}
+	defer fork.Close()
+	server, err := New(fork)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer server.Stop()
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	rpcClient, err := rpc.Dial(srv.URL)
+	if err != nil {
+		t.Fatalf("dial server: %v", err)
+	}
+	client := ethclient.NewClient(rpcClient)
+	ctx := context.Background()
+
+	if n, err := client.BlockNumber(ctx); err != nil || n != testBlock {
+		t.Fatalf("eth_blockNumber = %d, %v", n, err)
+	}
+
+	// eth_call leaves no changes behind
+	ret, err := client.CallContract(ctx, ethereum.CallMsg{From: sender, To: &counter}, nil)
+	if err != nil || new(big.Int).SetBytes(ret).Int64() != 1 {
+		t.Fatalf("eth_call = %x, %v", ret, err)
+	}
+	if v, _ := client.StorageAt(ctx, counter, common.Hash{}, nil); new(big.Int).SetBytes(v).Sign() != 0 {
+		t.Errorf("counter slot 0 = %x after eth_call", v)
+	}
+	gas, err := client.EstimateGas(ctx, ethereum.CallMsg{From: sender, To: &counter})
+	if err != nil || gas <= 21000 {
+		t.Errorf("eth_estimateGas = %d, %v", gas, err)
+	}
+
+	sign := func(nonce uint64, to common.Address) *types.Transaction {
+		t.Helper()
+		head, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			t.Fatalf("latest header: %v", err)
+		}
+		tx, _ := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: new(big.Int).Mul(head.BaseFee, big.NewInt(2)),
+			Gas:       100000,
+			To:        &to,
+			Value:     big.NewInt(1e15),
+		})
+		return tx
+	}
+	send := func(nonce uint64, to common.Address) *types.Receipt {
+		t.Helper()
+		tx := sign(nonce, to)
+		if err := client.SendTransaction(ctx, tx); err != nil {
+			t.Fatalf("eth_sendRawTransaction: %v", err)
+		}
+		receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			t.Fatalf("eth_getTransactionReceipt: %v", err)
+		}
+		return receipt
+	}
+
+	// eth_callBundle goes on past a revert and keeps nothing
+	var bundle callBundleResult
+	var raw []hexutil.Bytes
+	for i, to := range []common.Address{payer, reverter, counter} {
+		b, _ := sign(uint64(i), to).MarshalBinary()
+		raw = append(raw, b)
+	}
+	if err := rpcClient.Call(&bundle, "eth_callBundle", map[string]interface{}{
+		"txs": raw, "blockNumber": hexutil.Uint64(testBlock + 1), "stateBlockNumber": "latest",
+	}); err != nil {
+		t.Fatalf("eth_callBundle: %v", err)
+	}
+	if len(bundle.Results) != 3 || bundle.StateBlockNumber != testBlock {
+		t.Fatalf("eth_callBundle = %+v", bundle)
+	}
+	paid := bundle.Results[0]
+	if paid.Value == nil || paid.EthSentToCoinbase != "1000000000000000" || paid.FromAddress != sender {
+		t.Errorf("payer result = %+v", paid)
+	}
+	if r := bundle.Results[1]; r.Error != "execution reverted" || r.Value != nil {
+		t.Errorf("reverter result = %+v", r)
+	}
+	if r := bundle.Results[2]; r.Value == nil || new(big.Int).SetBytes(*r.Value).Int64() != 1 {
+		t.Errorf("counter result = %+v", r)
+	}
+	var gasUsed uint64
+	for _, r := range bundle.Results {
+		gasUsed += r.GasUsed
+	}
+	if bundle.TotalGasUsed != gasUsed || bundle.EthSentToCoinbase != "1000000000000000" {
+		t.Errorf("bundle totals = %+v", bundle)
+	}
+	if nonce, _ := client.NonceAt(ctx, sender, nil); nonce != 0 {
+		t.Errorf("sender nonce = %d after eth_callBundle", nonce)
+	}
+
+	snap := new(hexutil.Uint64)
+	if err := rpcClient.Call(snap, "evm_snapshot"); err != nil {
+		t.Fatalf("evm_snapshot: %v", err)
+	}
+
+	receipt := send(0, recipient)
+	if receipt.Status != types.ReceiptStatusSuccessful || receipt.BlockNumber.Uint64() != testBlock+1 {
+		t.Errorf("receipt status %d block %s", receipt.Status, receipt.BlockNumber)
+	}
+	receipt = send(1, counter)
+	if receipt.BlockNumber.Uint64() != testBlock+2 {
+		t.Errorf("second tx in block %s, want %d", receipt.BlockNumber, testBlock+2)
+	}
+	if bal, _ := client.BalanceAt(ctx, recipient, nil); bal.Cmp(big.NewInt(1e15)) != 0 {
+		t.Errorf("recipient balance = %s, want 1e15", bal)
+	}
+	if v, _ := client.StorageAt(ctx, counter, common.Hash{}, nil); new(big.Int).SetBytes(v).Int64() != 1 {
+		t.Errorf("counter slot 0 = %x, want 1", v)
+	}
+	if _, err := client.BalanceAt(ctx, recipient, big.NewInt(testBlock)); err == nil {
+		t.Error("historical state query succeeded")
+	}
+
+	// fee suggestions follow the next block's base fee
+	history, err := client.FeeHistory(ctx, 10, nil, []float64{50})
+	if err != nil || history.OldestBlock.Uint64() != testBlock || len(history.BaseFee) != 4 || len(history.Reward) != 3 {
+		t.Fatalf("eth_feeHistory = %+v, %v", history, err)
+	}
+	if history.Reward[1][0].Cmp(big.NewInt(1e9)) != 0 {
+		t.Errorf("eth_feeHistory reward = %s, want the 1 gwei tip", history.Reward[1][0])
+	}
+	tip, err := client.SuggestGasTipCap(ctx)
+	if err != nil || tip.Cmp(suggestedTip) != 0 {
+		t.Errorf("eth_maxPriorityFeePerGas = %s, %v", tip, err)
+	}
+	price, err := client.SuggestGasPrice(ctx)
+	if want := new(big.Int).Add(history.BaseFee[3], tip); err != nil || price.Cmp(want) != 0 {
+		t.Errorf("eth_gasPrice = %s, %v, want %s", price, err, want)
+	}
+	if id, err := client.NetworkID(ctx); err != nil || id.Int64() != 1 {
+		t.Errorf("net_version = %s, %v", id, err)
+	}
+
+	// so is a tx signed for another chain
+	other, _ := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(5)), &types.LegacyTx{
+		Nonce: 2, GasPrice: big.NewInt(100e9), Gas: 21000, To: &recipient,
+	})
+	if err := client.SendTransaction(ctx, other); err == nil || !strings.Contains(err.Error(), "chain id") {
+		t.Errorf("tx for chain 5: %v", err)
+	}
+
+	// a stale nonce is rejected and mines nothing
+	head, _ := client.BlockNumber(ctx)
+	tx, _ := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+		Nonce: 0, GasPrice: big.NewInt(100e9), Gas: 21000, To: &recipient,
+	})
+	if err := client.SendTransaction(ctx, tx); err == nil || !strings.Contains(err.Error(), "nonce too low") {
+		t.Errorf("stale nonce tx: %v", err)
+	}
+	if n, _ := client.BlockNumber(ctx); n != head {
+		t.Errorf("block number = %d after rejected tx, want %d", n, head)
+	}
+
+	if err := rpcClient.Call(nil, "evm_mine"); err != nil {
+		t.Fatalf("evm_mine: %v", err)
+	}
+	if n, _ := client.BlockNumber(ctx); n != head+1 {
+		t.Errorf("block number = %d after evm_mine, want %d", n, head+1)
+	}
+
+	// back to the fork's state
+	var ok bool
+	if err := rpcClient.Call(&ok, "evm_revert", snap); err != nil || !ok {
+		t.Fatalf("evm_revert = %v, %v", ok, err)
+	}
+	if n, _ := client.BlockNumber(ctx); n != testBlock {
+		t.Errorf("block number = %d after revert, want %d", n, testBlock)
+	}
+	if bal, _ := client.BalanceAt(ctx, recipient, nil); bal.Sign() != 0 {
+		t.Errorf("recipient balance = %s after revert", bal)
+	}
+	if _, err := client.TransactionReceipt(ctx, receipt.TxHash); err != ethereum.NotFound {
+		t.Errorf("receipt after revert: %v", err)
+	}
+	if err := rpcClient.Call(&ok, "evm_revert", snap); err != nil || ok {
+		t.Errorf("second evm_revert = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,217 @@
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+// ErrStateDiverged is returned by Advance when the simulated block does not
+// reproduce the on-chain receipts
+var ErrStateDiverged = errors.New("simulated block diverged from on-chain receipts")
+
+// ReceiptMismatch is one field where a simulated tx disagrees with its receipt
+type ReceiptMismatch struct {
+	TxIndex   int
+	TxHash    common.Hash
+	Field     string
+	Simulated string
+	OnChain   string
+}
+
+func (m ReceiptMismatch) String() string {
+	return fmt.Sprintf("tx %d (%s): %s simulated=%s on-chain=%s",
+		m.TxIndex, m.TxHash.Hex()[:10], m.Field, m.Simulated, m.OnChain)
+}
+
+// CompareReceipt checks a simulated tx against its on-chain receipt.
+// cumulative is the block's simulated gas used up to and including this tx.
+func CompareReceipt(index int, tx *types.Transaction, result *SimulationResult, cumulative uint64, receipt *types.Receipt) []ReceiptMismatch {
+	var out []ReceiptMismatch
+	add := func(field string, sim, chain interface{}) {
+		out = append(out, ReceiptMismatch{
+			TxIndex:   index,
+			TxHash:    tx.Hash(),
+			Field:     field,
+			Simulated: fmt.Sprint(sim),
+			OnChain:   fmt.Sprint(chain),
+		})
+	}
+
+	status := types.ReceiptStatusFailed
+	if result.Success {
+		status = types.ReceiptStatusSuccessful
+	}
+	if status != receipt.Status {
+		add("status", status, receipt.Status)
+	}
+	if result.GasUsed != receipt.GasUsed {
+		add("gasUsed", result.GasUsed, receipt.GasUsed)
+	}
+	if cumulative != receipt.CumulativeGasUsed {
+		add("cumulativeGasUsed", cumulative, receipt.CumulativeGasUsed)
+	}
+	if result.BlobGasUsed != receipt.BlobGasUsed {
+		add("blobGasUsed", result.BlobGasUsed, receipt.BlobGasUsed)
+	}
+
+	if len(result.Logs) != len(receipt.Logs) {
+		add("logCount", len(result.Logs), len(receipt.Logs))
+	}
+	for i := 0; i < len(result.Logs) && i < len(receipt.Logs); i++ {
+		sim, chain := result.Logs[i], receipt.Logs[i]
+		if sim.Address != chain.Address {
+			add(fmt.Sprintf("logs[%d].address", i), sim.Address.Hex(), chain.Address.Hex())
+		}
+		if !equalTopics(sim.Topics, chain.Topics) {
+			add(fmt.Sprintf("logs[%d].topics", i), sim.Topics, chain.Topics)
+		}
+		if !bytes.Equal(sim.Data, chain.Data) {
+			add(fmt.Sprintf("logs[%d].data", i), hexutil.Encode(sim.Data), hexutil.Encode(chain.Data))
+		}
+	}
+	return out
+}
+
+func equalTopics(a, b []common.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Advance applies block N+1 on top of the fork so it becomes the post-state
+// of N+1, keeping the warm in-memory cache. Every tx is checked against the
+// block's receipts; on any mismatch the fork is left at N and the result is
+// returned together with ErrStateDiverged. Outstanding snapshots are dropped.
+// Only post-merge blocks are supported (no block rewards are paid), and only
+// on root forks.
+func (f *StateFork) Advance() (*AdvanceResult, error) {
+	if f.parent != nil {
+		return nil, errors.New("a child fork can't advance, its parent's state is at a fixed block")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	next := new(big.Int).Add(f.blockNumber, big.NewInt(1))
+	block, err := f.client.BlockByNumber(ctx, next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch block %s: %w", next, err)
+	}
+	if block.ParentHash() != f.block.Hash() {
+		return nil, fmt.Errorf("block %s does not build on forked block %s", next, f.blockNumber)
+	}
+	if block.Difficulty().Sign() != 0 {
+		return nil, fmt.Errorf("block %s is pre-merge, advancing over PoW blocks is not supported", next)
+	}
+
+	receipts, err := f.client.GetBlockReceipts(ctx, next.Uint64())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch receipts for block %s: %w", next, err)
+	}
+
+	snap := f.Snapshot()
+	result, err := f.ApplyBlock(block, receipts)
+	if err != nil {
+		f.RevertToSnapshot(snap)
+		return nil, err
+	}
+	if len(result.Mismatches) > 0 {
+		f.RevertToSnapshot(snap)
+		return result, fmt.Errorf("block %s: %w: %s", next, ErrStateDiverged, result.Mismatches[0])
+	}
+
+	f.blockHashesMu.Lock()
+	f.blockHashes[next.Uint64()] = block.Hash()
+	f.blockHashesMu.Unlock()
+
+	f.mu.Lock()
+	f.blockNumber = next
+	f.block = block
+	f.journal.reset()
+	f.mu.Unlock()
+
+	return result, nil
+}
+
+// ApplyBlock executes every tx of block on the fork's current state, including
+// the pre/post block system operations, and compares each one with receipts.
+// It keeps going past mismatches so all of them are reported. The fork's block
+// number is not changed; use Advance to move a fork forward.
+func (f *StateFork) ApplyBlock(block *types.Block, receipts []*types.Receipt) (*AdvanceResult, error) {
+	if len(receipts) != len(block.Transactions()) {
+		return nil, fmt.Errorf("block %s has %d txs but %d receipts", block.Number(), len(block.Transactions()), len(receipts))
+	}
+
+	executor := NewExecutor(f)
+	executor.preBlock(block)
+
+	result := &AdvanceResult{
+		BlockNumber:  block.NumberU64(),
+		Transactions: make([]*TxResult, 0, len(block.Transactions())),
+	}
+	for i, tx := range block.Transactions() {
+		simResult, err := executor.ExecuteTransaction(tx, block)
+		if err != nil {
+			return nil, fmt.Errorf("block %s tx %d: %w", block.Number(), i, err)
+		}
+		result.GasUsed += simResult.GasUsed
+		result.Transactions = append(result.Transactions, newTxResult(tx, simResult))
+		result.Mismatches = append(result.Mismatches, CompareReceipt(i, tx, simResult, result.GasUsed, receipts[i])...)
+	}
+
+	if err := executor.postBlock(block); err != nil {
+		return nil, fmt.Errorf("block %s: %w", block.Number(), err)
+	}
+	return result, nil
+}
+
+// preBlock runs the system calls that happen before a block's first tx
+func (e *Executor) preBlock(block *types.Block) {
+	evm := vm.NewEVM(e.blockContext(block), NewForkedStateDB(e.fork), e.config, vm.Config{})
+	if root := block.BeaconRoot(); root != nil && e.config.IsCancun(block.Number(), block.Time()) {
+		core.ProcessBeaconBlockRoot(*root, evm)
+	}
+	if e.config.IsPrague(block.Number(), block.Time()) {
+		core.ProcessParentBlockHash(block.ParentHash(), evm)
+	}
+}
+
+// postBlock credits withdrawals and drains the EIP-7002/7251 request queues
+func (e *Executor) postBlock(block *types.Block) error {
+	stateDB := NewForkedStateDB(e.fork)
+	for _, w := range block.Withdrawals() {
+		amount := new(uint256.Int).Mul(uint256.NewInt(w.Amount), uint256.NewInt(params.GWei))
+		stateDB.AddBalance(w.Address, amount, tracing.BalanceIncreaseWithdrawal)
+	}
+	stateDB.Finalise(true)
+
+	if e.config.IsPrague(block.Number(), block.Time()) {
+		evm := vm.NewEVM(e.blockContext(block), stateDB, e.config, vm.Config{})
+		var requests [][]byte
+		if err := core.ProcessWithdrawalQueue(&requests, evm); err != nil {
+			return fmt.Errorf("withdrawal queue: %w", err)
+		}
+		if err := core.ProcessConsolidationQueue(&requests, evm); err != nil {
+			return fmt.Errorf("consolidation queue: %w", err)
+		}
+	}
+	return nil
+}
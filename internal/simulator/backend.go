@@ -0,0 +1,63 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+)
+
+// ForkBackend is an eth.Backend answering state reads and calls from the
+// fork's current state, so code written against an RPC client (pool loading,
+// detection) sees simulated changes. The block number argument of those is
+// ignored; blocks, receipts and traces still come from the fork's client.
+// Calls run in block's context and leave no changes behind.
+type ForkBackend struct {
+	eth.Backend
+	fork     *StateFork
+	executor *Executor
+	block    *types.Block
+}
+
+var _ eth.Backend = (*ForkBackend)(nil)
+
+// NewForkBackend serves f's state. block is the block calls execute in, e.g.
+// the one whose txs were applied on top of the fork.
+func NewForkBackend(f *StateFork, block *types.Block) *ForkBackend {
+	return &ForkBackend{Backend: f.client, fork: f, executor: NewExecutor(f), block: block}
+}
+
+func (b *ForkBackend) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return b.fork.GetBalance(account)
+}
+
+func (b *ForkBackend) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	return b.fork.GetCode(account)
+}
+
+func (b *ForkBackend) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	val, err := b.fork.GetStorageAt(account, key)
+	return val.Bytes(), err
+}
+
+func (b *ForkBackend) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return b.fork.GetNonce(account)
+}
+
+func (b *ForkBackend) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	snapID := b.fork.Snapshot()
+	defer b.fork.RevertToSnapshot(snapID)
+
+	result, err := b.executor.ExecuteCall(msg, b.block)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, fmt.Errorf("%s", result.RevertReason)
+	}
+	return result.ReturnData, nil
+}
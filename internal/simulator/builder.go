@@ -0,0 +1,328 @@
+package simulator
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Bundle is a candidate bundle for BlockBuilder
+type Bundle struct {
+	Txs     []*types.Transaction
+	Options BundleOptions
+}
+
+// BlockBuilder packs mempool txs and bundles into a block on top of the fork,
+// greedily by what each pays the coinbase per gas: the effective priority
+// fee for a tx, total coinbase payment over gas used for a bundle.
+type BlockBuilder struct {
+	fork     *StateFork
+	executor *Executor
+	bundles  *BundleSimulator
+	maxTxs   int // mempool txs to pack at most; 0 = no limit
+}
+
+func NewBlockBuilder(f *StateFork) *BlockBuilder {
+	executor := NewExecutor(f)
+	return &BlockBuilder{
+		fork:     f,
+		executor: executor,
+		bundles:  &BundleSimulator{executor: executor, quiet: true},
+	}
+}
+
+// SetMaxTxs caps the mempool txs packed into the block. Bundles don't count
+// toward it.
+func (b *BlockBuilder) SetMaxTxs(n int) {
+	b.maxTxs = n
+}
+
+// bundleCandidate is a bundle that works at the top of the block
+type bundleCandidate struct {
+	bundle    Bundle
+	inclusion *BundleInclusion
+	gasUsed   uint64
+}
+
+// Build packs a block with block's header (number, time, coinbase, base fee,
+// gas limit) from the mempool txs and bundles; block's own txs are ignored.
+// Txs from one sender go in nonce order. A tx that can't be included is
+// dropped along with the sender's later txs; one that reverts stays in, as it
+// would on chain. A bundle goes in whole or not at all. The fork is left as
+// it was.
+func (b *BlockBuilder) Build(block *types.Block, mempool []*types.Transaction, bundles []Bundle) (*BuildResult, error) {
+	snapID := b.fork.Snapshot()
+	defer b.fork.RevertToSnapshot(snapID)
+	return b.build(block, mempool, bundles)
+}
+
+// Apply is Build but keeps the packed block's changes on the fork
+func (b *BlockBuilder) Apply(block *types.Block, mempool []*types.Transaction, bundles []Bundle) (*BuildResult, error) {
+	return b.build(block, mempool, bundles)
+}
+
+func (b *BlockBuilder) build(block *types.Block, mempool []*types.Transaction, bundles []Bundle) (*BuildResult, error) {
+	result := &BuildResult{
+		BlockNumber:  block.NumberU64(),
+		Transactions: make([]*TxResult, 0, len(mempool)),
+		Bundles:      make([]*BundleInclusion, len(bundles)),
+		CoinbaseDiff: new(big.Int),
+		GasFees:      new(big.Int),
+		BurntFees:    new(big.Int),
+	}
+	gasLeft := block.GasLimit()
+	included := make(map[common.Hash]bool)
+
+	// rank bundles by what they pay when run first
+	var candidates []*bundleCandidate
+	for i, bundle := range bundles {
+		inclusion := &BundleInclusion{Position: -1}
+		result.Bundles[i] = inclusion
+
+		top, err := b.simulateBundle(bundle, block)
+		if err != nil {
+			return nil, fmt.Errorf("bundle %d: %w", i, err)
+		}
+		inclusion.Result = top
+		if !top.Success {
+			inclusion.Reason = bundleFailure(top)
+			continue
+		}
+		inclusion.GasPrice = new(big.Int)
+		if top.TotalGasUsed > 0 {
+			inclusion.GasPrice.Div(top.CoinbaseDiff, new(big.Int).SetUint64(top.TotalGasUsed))
+		}
+		candidates = append(candidates, &bundleCandidate{bundle: bundle, inclusion: inclusion, gasUsed: top.TotalGasUsed})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].inclusion.GasPrice.Cmp(candidates[j].inclusion.GasPrice) > 0
+	})
+
+	senders := b.groupBySender(mempool, block.BaseFee(), result)
+	packed := 0
+
+	for gasLeft >= params.TxGas {
+		txsLeft := senders.Len() > 0 && (b.maxTxs == 0 || packed < b.maxTxs)
+		if len(candidates) == 0 && !txsLeft {
+			break
+		}
+		// a bundle goes first if it pays at least as much per gas as the best tx
+		if len(candidates) > 0 && (!txsLeft || candidates[0].inclusion.GasPrice.Cmp(senders[0].tip) >= 0) {
+			c := candidates[0]
+			candidates = candidates[1:]
+			if err := b.includeBundle(c, block, &gasLeft, included, result); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		head := senders[0]
+		tx := head.txs[0]
+		if included[tx.Hash()] {
+			head.shift(block.BaseFee(), &senders, result)
+			continue
+		}
+		if tx.Gas() > gasLeft {
+			// the sender's later txs depend on this one
+			head.drop("gas limit exceeds the gas left in the block", &senders, result)
+			continue
+		}
+
+		sim, err := b.executor.ExecuteTransaction(tx, block)
+		if err != nil {
+			return nil, fmt.Errorf("tx %s: %w", tx.Hash().Hex(), err)
+		}
+		// fees are only unset when the tx wasn't included
+		if sim.EffectiveGasPrice == nil {
+			if errors.Is(sim.Err, core.ErrNonceTooLow) {
+				// already mined or replaced, the next nonce may still go in
+				result.Dropped = append(result.Dropped, DroppedTx{tx.Hash(), sim.RevertReason})
+				head.shift(block.BaseFee(), &senders, result)
+			} else {
+				head.drop(sim.RevertReason, &senders, result)
+			}
+			continue
+		}
+
+		result.add(tx, sim)
+		gasLeft -= sim.GasUsed
+		included[tx.Hash()] = true
+		packed++
+		head.shift(block.BaseFee(), &senders, result)
+	}
+
+	for _, c := range candidates {
+		c.inclusion.Reason = "block full"
+	}
+	return result, nil
+}
+
+// simulateBundle runs bundle at the current state and undoes it
+func (b *BlockBuilder) simulateBundle(bundle Bundle, block *types.Block) (*BundleResult, error) {
+	snapID := b.fork.Snapshot()
+	defer b.fork.RevertToSnapshot(snapID)
+	return b.bundles.ExecuteBundleWithOptions(bundle.Txs, block, bundle.Options)
+}
+
+// includeBundle appends c to the block if it still fits and still succeeds
+// after what's already in
+func (b *BlockBuilder) includeBundle(c *bundleCandidate, block *types.Block, gasLeft *uint64, included map[common.Hash]bool, result *BuildResult) error {
+	if c.gasUsed > *gasLeft {
+		c.inclusion.Reason = "not enough gas left in the block"
+		return nil
+	}
+
+	snapID := b.fork.Snapshot()
+	r, err := b.bundles.ExecuteBundleWithOptions(c.bundle.Txs, block, c.bundle.Options)
+	if err != nil {
+		b.fork.RevertToSnapshot(snapID)
+		return err
+	}
+	c.inclusion.Result = r
+	if !r.Success {
+		b.fork.RevertToSnapshot(snapID)
+		c.inclusion.Reason = "fails after the txs ahead of it: " + bundleFailure(r)
+		return nil
+	}
+	if r.TotalGasUsed > *gasLeft {
+		b.fork.RevertToSnapshot(snapID)
+		c.inclusion.Reason = "not enough gas left in the block"
+		return nil
+	}
+	b.fork.discardSnapshot(snapID)
+
+	c.inclusion.Included = true
+	c.inclusion.Position = len(result.Transactions)
+	for _, tx := range r.Transactions {
+		// allowed-to-fail txs that couldn't be included were dropped
+		if tx.EffectiveGasPrice == nil {
+			continue
+		}
+		result.Transactions = append(result.Transactions, tx)
+		included[tx.TxHash] = true
+	}
+	result.GasUsed += r.TotalGasUsed
+	result.CoinbaseDiff.Add(result.CoinbaseDiff, r.CoinbaseDiff)
+	result.GasFees.Add(result.GasFees, r.GasFees)
+	result.BurntFees.Add(result.BurntFees, r.BurntFees)
+	*gasLeft -= r.TotalGasUsed
+	return nil
+}
+
+// bundleFailure says why a bundle run failed
+func bundleFailure(r *BundleResult) string {
+	if r.Invalid != "" {
+		return r.Invalid
+	}
+	tx := r.Transactions[r.RevertedAt]
+	return fmt.Sprintf("tx %d (%s) failed: %s", r.RevertedAt, tx.TxHash.Hex(), tx.RevertReason)
+}
+
+func (r *BuildResult) add(tx *types.Transaction, sim *SimulationResult) {
+	r.Transactions = append(r.Transactions, newTxResult(tx, sim))
+	r.GasUsed += sim.GasUsed
+	r.CoinbaseDiff.Add(r.CoinbaseDiff, sim.CoinbaseDiff)
+	r.GasFees.Add(r.GasFees, sim.GasFees)
+	r.BurntFees.Add(r.BurntFees, sim.BurntFees)
+}
+
+// senderTxs is one sender's pending txs in nonce order, ranked by the
+// effective priority fee of the first
+type senderTxs struct {
+	txs   []*types.Transaction
+	tip   *big.Int
+	index int
+}
+
+// senderHeap orders senders by their next tx's tip, highest first
+type senderHeap []*senderTxs
+
+func (h senderHeap) Len() int           { return len(h) }
+func (h senderHeap) Less(i, j int) bool { return h[i].tip.Cmp(h[j].tip) > 0 }
+func (h senderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *senderHeap) Push(x interface{}) {
+	s := x.(*senderTxs)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+func (h *senderHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// groupBySender queues the mempool per sender. Txs with a bad signature or a
+// fee cap below the base fee are dropped up front.
+func (b *BlockBuilder) groupBySender(mempool []*types.Transaction, baseFee *big.Int, result *BuildResult) senderHeap {
+	bySender := make(map[common.Address][]*types.Transaction)
+	seen := make(map[common.Hash]bool)
+	for _, tx := range mempool {
+		if seen[tx.Hash()] {
+			continue
+		}
+		seen[tx.Hash()] = true
+		from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		if err != nil {
+			result.Dropped = append(result.Dropped, DroppedTx{tx.Hash(), fmt.Sprintf("invalid sender: %v", err)})
+			continue
+		}
+		bySender[from] = append(bySender[from], tx)
+	}
+
+	senders := make(senderHeap, 0, len(bySender))
+	for _, txs := range bySender {
+		sort.SliceStable(txs, func(i, j int) bool { return txs[i].Nonce() < txs[j].Nonce() })
+		s := &senderTxs{txs: txs}
+		if s.price(baseFee, result) {
+			heap.Push(&senders, s)
+		}
+	}
+	return senders
+}
+
+// price ranks the sender by its next tx, dropping the rest of its txs if
+// that one can't pay the base fee. Returns false if nothing is left.
+func (s *senderTxs) price(baseFee *big.Int, result *BuildResult) bool {
+	if len(s.txs) == 0 {
+		return false
+	}
+	tip, err := s.txs[0].EffectiveGasTip(baseFee)
+	if err != nil {
+		for _, tx := range s.txs {
+			result.Dropped = append(result.Dropped, DroppedTx{tx.Hash(), err.Error()})
+		}
+		return false
+	}
+	s.tip = tip
+	return true
+}
+
+// shift moves the sender on to its next tx, re-ranking or removing it
+func (s *senderTxs) shift(baseFee *big.Int, senders *senderHeap, result *BuildResult) {
+	s.txs = s.txs[1:]
+	if s.price(baseFee, result) {
+		heap.Fix(senders, s.index)
+	} else {
+		heap.Remove(senders, s.index)
+	}
+}
+
+// drop removes the sender, its next tx failing for reason
+func (s *senderTxs) drop(reason string, senders *senderHeap, result *BuildResult) {
+	result.Dropped = append(result.Dropped, DroppedTx{s.txs[0].Hash(), reason})
+	for _, tx := range s.txs[1:] {
+		result.Dropped = append(result.Dropped, DroppedTx{tx.Hash(), "follows a dropped tx"})
+	}
+	heap.Remove(senders, s.index)
+}
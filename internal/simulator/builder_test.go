@@ -0,0 +1,132 @@
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestBlockBuilder(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 6)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	alice, bob, carol, dave, searcher, loser := keys[0], keys[1], keys[2], keys[3], keys[4], keys[5]
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	payer := common.HexToAddress("0x6666666666666666666666666666666666666666")
+	reverter := common.HexToAddress("0x7777777777777777777777777777777777777777")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		for _, key := range keys {
+			n.SetBalance(testBlock, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1e18))
+		}
+		// call(gas, coinbase, callvalue, 0, 0, 0, 0)
+		n.SetCode(testBlock, payer, common.FromHex("600060006000600034415af100"))
+		n.SetCode(testBlock, reverter, common.FromHex("60006000fd"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	sign := func(key *ecdsa.PrivateKey, nonce uint64, to common.Address, tip, feeCap, value int64) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(tip),
+			GasFeeCap: big.NewInt(feeCap),
+			Gas:       100000,
+			To:        &to,
+			Value:     big.NewInt(value),
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return tx
+	}
+
+	alice0 := sign(alice, 0, recipient, 2e9, 100e9, 1)
+	alice1 := sign(alice, 1, recipient, 2e9, 100e9, 1)
+	bob0 := sign(bob, 0, recipient, 5e9, 100e9, 1)
+	underpriced := sign(carol, 0, recipient, 1e9, 5e9, 1) // fee cap below the 10 gwei base fee
+	gapped := sign(dave, 3, recipient, 3e9, 100e9, 1)     // nonce too high
+	mempool := []*types.Transaction{alice1, underpriced, alice0, gapped, bob0}
+
+	// pays 0.01 ETH directly, far above any tip
+	backrun := sign(searcher, 0, payer, 0, 100e9, 1e16)
+	// reverts at the top of the block
+	failing := sign(loser, 0, reverter, 50e9, 100e9, 0)
+	// ranks below bob's tip, and bob's nonce is used by then
+	outbid := sign(bob, 0, payer, 1e9, 100e9, 0)
+	bundles := []Bundle{
+		{Txs: []*types.Transaction{backrun}},
+		{Txs: []*types.Transaction{failing}},
+		{Txs: []*types.Transaction{outbid}},
+	}
+
+	result, err := NewBlockBuilder(fork).Build(block, mempool, bundles)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	want := []common.Hash{backrun.Hash(), bob0.Hash(), alice0.Hash(), alice1.Hash()}
+	if len(result.Transactions) != len(want) {
+		t.Fatalf("built %d txs, want %d: %+v", len(result.Transactions), len(want), result.Transactions)
+	}
+	value := new(big.Int)
+	var gasUsed uint64
+	for i, tx := range result.Transactions {
+		if tx.TxHash != want[i] {
+			t.Errorf("tx %d = %s, want %s", i, tx.TxHash.Hex(), want[i].Hex())
+		}
+		value.Add(value, tx.CoinbaseDiff)
+		gasUsed += tx.GasUsed
+	}
+	if result.CoinbaseDiff.Cmp(value) != 0 || result.GasUsed != gasUsed || value.Cmp(big.NewInt(1e16)) <= 0 {
+		t.Errorf("block value %s gas %d, txs add up to %s gas %d", result.CoinbaseDiff, result.GasUsed, value, gasUsed)
+	}
+
+	if b := result.Bundles[0]; !b.Included || b.Position != 0 {
+		t.Errorf("backrun bundle = %+v", b)
+	}
+	if b := result.Bundles[1]; b.Included || b.Reason == "" || b.GasPrice != nil {
+		t.Errorf("failing bundle = %+v", b)
+	} else if err := b.Result.Transactions[0].Err; !errors.Is(err, vm.ErrExecutionReverted) {
+		t.Errorf("failing bundle tx error = %v, want %v", err, vm.ErrExecutionReverted)
+	}
+	if b := result.Bundles[2]; b.Included || b.GasPrice == nil || b.Reason == "" {
+		t.Errorf("outbid bundle = %+v", b)
+	}
+
+	dropped := make(map[common.Hash]bool)
+	for _, d := range result.Dropped {
+		dropped[d.TxHash] = true
+	}
+	if len(dropped) != 2 || !dropped[underpriced.Hash()] || !dropped[gapped.Hash()] {
+		t.Errorf("dropped = %+v", result.Dropped)
+	}
+
+	// building leaves the fork alone
+	if nonce, _ := fork.GetNonce(crypto.PubkeyToAddress(alice.PublicKey)); nonce != 0 {
+		t.Errorf("alice nonce = %d after Build", nonce)
+	}
+
+	// the cap counts txs as they go in, so alice's well paying second tx
+	// still waits for her first
+	eager := sign(alice, 1, recipient, 9e9, 100e9, 1)
+	builder := NewBlockBuilder(fork)
+	builder.SetMaxTxs(2)
+	capped, err := builder.Build(block, []*types.Transaction{eager, alice0, bob0}, nil)
+	if err != nil {
+		t.Fatalf("Build with cap: %v", err)
+	}
+	if len(capped.Transactions) != 2 || capped.Transactions[0].TxHash != bob0.Hash() || capped.Transactions[1].TxHash != alice0.Hash() {
+		t.Errorf("capped build = %+v", capped.Transactions)
+	}
+}
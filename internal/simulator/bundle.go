@@ -8,30 +8,63 @@ import (
 
 type BundleSimulator struct {
 	executor *Executor
+	quiet bool // no progress output, for callers running many bundles
 }
 
 func NewBundleSimulator(f *StateFork) *BundleSimulator {
 	return &BundleSimulator{executor: NewExecutor(f)}
 }
 
+// SetTrackChanges turns on the state diffs of the bundle and its txs, see
+// Executor.SetTrackChanges
+func (b *BundleSimulator) SetTrackChanges(on bool) {
+	b.executor.SetTrackChanges(on)
+}
+
+// newTracker collects the bundle's diff if diffs are on, nil otherwise
+func (b *BundleSimulator) newTracker() *StateTracker {
+	if !b.executor.trackChanges {
+		return nil
+	}
+	return NewStateTracker(b.executor.fork)
+}
+
+func (b *BundleSimulator) logf(format string, args ...interface{}) {
+	if !b.quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
 // Execute bundle execs transactions atomically; all succed or all fail
 func (b *BundleSimulator) ExecuteBundle(txs []*types.Transaction, block *types.Block) (*BundleResult, error)  {
+	return b.ExecuteBundleWithOptions(txs, block, BundleOptions{})
+}
+
+// ExecuteBundleWithOptions is ExecuteBundle with relay bundle semantics: the
+// bundle only runs if block is in its target range and timestamp window, and
+// txs listed in RevertingTxHashes may revert (or not be includable at all, in
+// which case they're dropped) without failing it
+func (b *BundleSimulator) ExecuteBundleWithOptions(txs []*types.Transaction, block *types.Block, opts BundleOptions) (*BundleResult, error) {
 	if len(txs)==0 {
 		return nil, fmt.Errorf("empty bundle")
 	}
 
+	result := newBundleResult(len(txs))
+	result.Options = opts
+	if reason := opts.checkBlock(block); reason != "" {
+		b.logf("\nBundle not valid for block %d: %s\n", block.NumberU64(), reason)
+		result.Success = false
+		result.Invalid = reason
+		return result, nil
+	}
+
 	// taking a snapshot so we can revert back if bundle fails
 	snapID := b.executor.fork.Snapshot()
-	result := &BundleResult{
-		Success: true,
-		Transactions: make([]*TxResult, 0, len(txs)),
-		TotalGasUsed: 0,
-		RevertedAt: -1,
-	}
+	tracker := b.newTracker()
 
 	// exec each tx in order
 	for i, tx := range txs {
-		fmt.Printf("\nBundle[%d/%d]: Executing %s...\n", i+1, len(txs), tx.Hash().Hex())
+		b.logf("\nBundle[%d/%d]: Executing %s...\n", i+1, len(txs), tx.Hash().Hex())
 
 		simResult, err := b.executor.ExecuteTransaction(tx, block)
 		if err!=nil {
@@ -39,29 +72,63 @@ func (b *BundleSimulator) ExecuteBundle(txs []*types.Transaction, block *types.B
 			return nil, fmt.Errorf("\nbundle tx %d failed with error: %w", i, err)
 		}
 
-		txResult := &TxResult{
-			TxHash: tx.Hash(),
-			Success: simResult.Success,
-			GasUsed: simResult.GasUsed,
-			Logs: simResult.Logs,
-			ReturnData: simResult.ReturnData,
-			RevertReason: simResult.RevertReason,
+		result.add(tx, simResult, tracker)
+
+		if !simResult.Success && opts.AllowsRevert(tx.Hash()) {
+			b.logf("  └─ REVERTED (allowed): %s\n", simResult.RevertReason)
+			continue
 		}
-		result.Transactions = append(result.Transactions, txResult)
-		result.TotalGasUsed += simResult.GasUsed
 
 		// if tx failed, reverting entire bundle
 		if !simResult.Success {
-			fmt.Printf("  └─ REVERTED: %s\n", simResult.RevertReason)
+			b.logf("  └─ REVERTED: %s\n", simResult.RevertReason)
 			result.Success = false
 			result.RevertedAt = i
+			// diff what the bundle did up to the failure before undoing it
+			result.StateChanges = tracker.Changes()
 			b.executor.fork.RevertToSnapshot(snapID)
 			return result, nil
 		}
-		fmt.Printf("  └─ Success: %d gas\n", simResult.GasUsed)
+		b.logf("  └─ Success: %d gas\n", simResult.GasUsed)
+	}
+
+	// all txs succeed, keep their changes
+	result.StateChanges = tracker.Changes()
+	b.executor.fork.discardSnapshot(snapID)
+	b.logf("\n Bundle executed successfully: %d transactions, %d total gas n", len(txs), result.TotalGasUsed)
+	return result, nil
+}
+
+// CallBundle simulates txs in order the way eth_callBundle does: a reverted
+// tx is reported and the bundle carries on, a tx that can't be included at
+// all (bad nonce, fee cap below base fee, ...) fails the call. The fork is
+// left as it was either way.
+func (b *BundleSimulator) CallBundle(txs []*types.Transaction, block *types.Block) (*BundleResult, error) {
+	if len(txs) == 0 {
+		return nil, fmt.Errorf("empty bundle")
+	}
+
+	snapID := b.executor.fork.Snapshot()
+	defer b.executor.fork.RevertToSnapshot(snapID)
+	result := newBundleResult(len(txs))
+	tracker := b.newTracker()
+
+	for i, tx := range txs {
+		simResult, err := b.executor.ExecuteTransaction(tx, block)
+		if err != nil {
+			return nil, fmt.Errorf("bundle tx %d: %w", i, err)
+		}
+		// fees are only unset when the tx wasn't included
+		if simResult.EffectiveGasPrice == nil {
+			return nil, fmt.Errorf("bundle tx %d (%s): %s", i, tx.Hash().Hex(), simResult.RevertReason)
+		}
+		result.add(tx, simResult, tracker)
+		if !simResult.Success && result.Success {
+			result.Success = false
+			result.RevertedAt = i
+		}
 	}
 
-	// all txs succeed
-	fmt.Printf("\n Bundle executed successfully: %d transactions, %d total gas n", len(txs), result.TotalGasUsed)
+	result.StateChanges = tracker.Changes()
 	return result, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,113 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestBundleOptions(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	reverter := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	store := common.HexToAddress("0x6666666666666666666666666666666666666666")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, reverter, common.FromHex("60006000fd")) // revert(0, 0)
+		n.SetCode(testBlock, store, common.FromHex("600160005500"))  // sstore(0, 1)
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	sign := func(nonce uint64, to common.Address) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce: nonce, GasPrice: big.NewInt(100e9), Gas: 100000, To: &to,
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return tx
+	}
+	// a tx that can't be included, a reverting victim, then ours
+	future, victim, ours := sign(9, store), sign(0, reverter), sign(1, store)
+	txs := []*types.Transaction{future, victim, ours}
+	sim := NewBundleSimulator(fork)
+
+	result, err := sim.ExecuteBundle(txs, block)
+	if err != nil || result.Success || result.RevertedAt != 0 {
+		t.Fatalf("bundle without options = %v %+v", err, result)
+	}
+
+	// the target range and timestamps are checked before anything runs
+	for _, opts := range []BundleOptions{
+		{MinBlock: testBlock + 2},
+		{MaxBlock: testBlock},
+		{MinTimestamp: block.Time() + 1},
+		{MaxTimestamp: block.Time() - 1},
+	} {
+		result, err := sim.ExecuteBundleWithOptions(txs, block, opts)
+		if err != nil || result.Success || result.Invalid == "" || len(result.Transactions) != 0 {
+			t.Errorf("options %+v: %v %+v", opts, err, result)
+		}
+	}
+
+	opts := BundleOptions{
+		RevertingTxHashes: []common.Hash{future.Hash(), victim.Hash()},
+		MinBlock:          testBlock + 1,
+		MaxBlock:          testBlock + 3,
+		MinTimestamp:      block.Time(),
+		MaxTimestamp:      block.Time() + 60,
+	}
+	result, err = sim.ExecuteBundleWithOptions(txs, block, opts)
+	if err != nil || !result.Success || result.RevertedAt != -1 || result.Invalid != "" {
+		t.Fatalf("bundle with options = %v %+v", err, result)
+	}
+	if !result.Options.AllowsRevert(victim.Hash()) || result.Options.AllowsRevert(ours.Hash()) {
+		t.Errorf("result options = %+v", result.Options)
+	}
+	if r := result.Transactions; r[0].GasUsed != 0 || r[1].Success || r[1].GasUsed == 0 || !r[2].Success {
+		t.Errorf("tx results: dropped %+v, victim %+v, ours %+v", r[0], r[1], r[2])
+	}
+	// the dropped tx left no trace, the victim still used its nonce
+	if nonce, _ := fork.GetNonce(sender); nonce != 2 {
+		t.Errorf("sender nonce = %d, want 2", nonce)
+	}
+	if v, _ := fork.GetStorageAt(store, common.Hash{}); v != common.HexToHash("0x01") {
+		t.Errorf("store slot 0 = %s, want 1", v.Hex())
+	}
+}
+
+func TestExecuteBundleReleasesSnapshot(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	store := common.HexToAddress("0x6666666666666666666666666666666666666666")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, store, common.FromHex("600160005500")) // sstore(0, 1)
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	tx, _ := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+		Nonce: 0, GasPrice: big.NewInt(100e9), Gas: 100000, To: &store,
+	})
+	result, err := NewBundleSimulator(fork).ExecuteBundle([]*types.Transaction{tx}, block)
+	if err != nil || !result.Success {
+		t.Fatalf("ExecuteBundle: %v %+v", err, result)
+	}
+
+	// later writes must not be journaled for a snapshot nobody holds
+	if len(fork.journal.revisions) != 0 || len(fork.journal.entries) != 0 {
+		t.Errorf("journal after bundle: %d revisions, %d entries", len(fork.journal.revisions), len(fork.journal.entries))
+	}
+	if v, _ := fork.GetStorageAt(store, common.Hash{}); v != common.HexToHash("0x01") {
+		t.Errorf("store slot 0 = %s, want 1", v.Hex())
+	}
+}
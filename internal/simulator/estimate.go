@@ -0,0 +1,242 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// callMessage turns an unsigned call into a message for block. The nonce is
+// the sender's current one and nonce/EOA checks are skipped, like eth_call.
+// Without any gas price the base fee check is skipped too (noBaseFee).
+func (e *Executor) callMessage(call ethereum.CallMsg, block *types.Block) (msg *core.Message, noBaseFee bool, err error) {
+	nonce, err := e.fork.GetNonce(call.From)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get nonce of %s: %w", call.From.Hex(), err)
+	}
+
+	msg = &core.Message{
+		From:                  call.From,
+		To:                    call.To,
+		Nonce:                 nonce,
+		Value:                 call.Value,
+		GasLimit:              call.Gas,
+		Data:                  call.Data,
+		AccessList:            call.AccessList,
+		BlobHashes:            call.BlobHashes,
+		BlobGasFeeCap:         call.BlobGasFeeCap,
+		SetCodeAuthorizations: call.AuthorizationList,
+		SkipNonceChecks:       true,
+		SkipTransactionChecks: true,
+	}
+	if msg.Value == nil {
+		msg.Value = new(big.Int)
+	}
+	if msg.GasLimit == 0 {
+		msg.GasLimit = block.GasLimit()
+	}
+
+	baseFee := block.BaseFee()
+	switch {
+	case call.GasPrice != nil:
+		msg.GasPrice = call.GasPrice
+		msg.GasFeeCap = call.GasPrice
+		msg.GasTipCap = call.GasPrice
+	case call.GasFeeCap != nil || call.GasTipCap != nil:
+		msg.GasFeeCap, msg.GasTipCap = call.GasFeeCap, call.GasTipCap
+		if msg.GasFeeCap == nil {
+			msg.GasFeeCap = new(big.Int)
+		}
+		if msg.GasTipCap == nil {
+			msg.GasTipCap = new(big.Int)
+		}
+		msg.GasPrice = new(big.Int).Set(msg.GasFeeCap)
+		if baseFee != nil {
+			if price := new(big.Int).Add(msg.GasTipCap, baseFee); price.Cmp(msg.GasFeeCap) < 0 {
+				msg.GasPrice = price
+			}
+		}
+	default:
+		msg.GasPrice, msg.GasFeeCap, msg.GasTipCap = new(big.Int), new(big.Int), new(big.Int)
+	}
+	if msg.BlobGasFeeCap == nil && len(msg.BlobHashes) > 0 {
+		msg.BlobGasFeeCap = new(big.Int)
+	}
+	noBaseFee = msg.GasFeeCap.Sign() == 0 && (msg.BlobGasFeeCap == nil || msg.BlobGasFeeCap.Sign() == 0)
+	return msg, noBaseFee, nil
+}
+
+// dryRun executes msg on the fork and undoes everything it did. A nil result
+// with a nil error means the gas limit was below the intrinsic or floor gas.
+func (e *Executor) dryRun(msg *core.Message, noBaseFee bool, block *types.Block, cfg vm.Config) (*core.ExecutionResult, error) {
+	stateDB := NewForkedStateDB(e.fork)
+	defer e.fork.clearTransientStorage()
+	e.hashErr = nil
+
+	blockContext := e.blockContext(block)
+	if noBaseFee {
+		lowerBaseFees(&blockContext, msg)
+	}
+	cfg.NoBaseFee = noBaseFee
+	evm := vm.NewEVM(blockContext, stateDB, e.config, cfg)
+	evm.SetTxContext(core.NewEVMTxContext(msg))
+
+	snap := stateDB.Snapshot()
+	defer stateDB.RevertToSnapshot(snap)
+
+	gasLimit := block.GasLimit()
+	if msg.GasLimit > gasLimit {
+		gasLimit = msg.GasLimit
+	}
+	result, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(gasLimit))
+	if e.hashErr != nil {
+		return nil, fmt.Errorf("BLOCKHASH lookup failed: %w", e.hashErr)
+	}
+	if errors.Is(err, core.ErrIntrinsicGas) || errors.Is(err, core.ErrFloorDataGas) {
+		return nil, nil
+	}
+	return result, err
+}
+
+// EstimateGas is eth_estimateGas on the fork: it binary-searches the lowest
+// gas limit at which call succeeds on the current state. call.Gas caps the
+// search (block gas limit if 0). A call that fails even at the cap returns
+// its decoded revert reason.
+func (e *Executor) EstimateGas(call ethereum.CallMsg, block *types.Block) (uint64, error) {
+	msg, noBaseFee, err := e.callMessage(call, block)
+	if err != nil {
+		return 0, err
+	}
+	hi := msg.GasLimit
+
+	// the sender can't buy more gas than its balance covers
+	if msg.GasFeeCap.Sign() > 0 {
+		bal, err := e.fork.GetBalance(msg.From)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get balance of %s: %w", msg.From.Hex(), err)
+		}
+		available := new(big.Int).Sub(bal, msg.Value)
+		if available.Sign() < 0 {
+			return 0, fmt.Errorf("insufficient funds for transfer: %s", msg.From.Hex())
+		}
+		allowance := available.Div(available, msg.GasFeeCap)
+		if allowance.IsUint64() && allowance.Uint64() < hi {
+			hi = allowance.Uint64()
+		}
+	}
+
+	run := func(gas uint64) (*core.ExecutionResult, error) {
+		msg.GasLimit = gas
+		return e.dryRun(msg, noBaseFee, block, vm.Config{})
+	}
+
+	result, err := run(hi)
+	if err != nil {
+		return 0, err
+	}
+	if result == nil {
+		return 0, fmt.Errorf("gas limit %d below intrinsic gas", hi)
+	}
+	if result.Failed() {
+		if errors.Is(result.Err, vm.ErrExecutionReverted) && len(result.Revert()) > 0 {
+			return 0, fmt.Errorf("%w: %s", result.Err, DecodeRevert(result.Revert()))
+		}
+		return 0, fmt.Errorf("gas required exceeds %d: %w", hi, result.Err)
+	}
+
+	// anything below the gas consumed before refunds fails; most calls succeed
+	// with that plus a call stipend and the 63/64 margin for nested calls, so
+	// try it first
+	lo := result.MaxUsedGas - 1
+	optimistic := (result.MaxUsedGas + params.CallStipend) * 64 / 63
+	if optimistic < hi {
+		if result, err := run(optimistic); err != nil {
+			return 0, err
+		} else if result != nil && !result.Failed() {
+			hi = optimistic
+		} else {
+			lo = optimistic
+		}
+	}
+
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		result, err := run(mid)
+		if err != nil {
+			return 0, err
+		}
+		if result == nil || result.Failed() {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+// AccessListResult is what CreateAccessList found
+type AccessListResult struct {
+	AccessList   types.AccessList
+	GasUsed      uint64 // gas used with the access list applied
+	RevertReason string // set if the call fails
+}
+
+// CreateAccessList is eth_createAccessList on the fork. The call is traced
+// and every address and slot it touches is recorded; since warm accounts
+// change gas and therefore the paths taken, it reruns with the found list
+// until the list stops changing. The sender, recipient and precompiles are
+// left out, they are warm anyway.
+func (e *Executor) CreateAccessList(call ethereum.CallMsg, block *types.Block) (*AccessListResult, error) {
+	msg, noBaseFee, err := e.callMessage(call, block)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude := map[common.Address]struct{}{msg.From: {}}
+	if msg.To != nil {
+		exclude[*msg.To] = struct{}{}
+	} else {
+		exclude[crypto.CreateAddress(msg.From, msg.Nonce)] = struct{}{}
+	}
+	rules := e.config.Rules(block.Number(), block.Difficulty().Sign() == 0, block.Time())
+	for _, addr := range vm.ActivePrecompiles(rules) {
+		exclude[addr] = struct{}{}
+	}
+
+	prev := logger.NewAccessListTracer(msg.AccessList, exclude)
+	gasLimit := msg.GasLimit
+	for {
+		accessList := prev.AccessList()
+		tracer := logger.NewAccessListTracer(accessList, exclude)
+
+		msg.AccessList = accessList
+		msg.GasLimit = gasLimit
+		result, err := e.dryRun(msg, noBaseFee, block, vm.Config{Tracer: tracer.Hooks()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply call: %w", err)
+		}
+		if result == nil {
+			return nil, fmt.Errorf("gas limit %d below intrinsic gas", gasLimit)
+		}
+		if tracer.Equal(prev) {
+			out := &AccessListResult{AccessList: accessList, GasUsed: result.UsedGas}
+			if result.Failed() {
+				out.RevertReason = result.Err.Error()
+				if errors.Is(result.Err, vm.ErrExecutionReverted) && len(result.Revert()) > 0 {
+					out.RevertReason = fmt.Sprintf("%s: %s", result.Err, DecodeRevert(result.Revert()))
+				}
+			}
+			return out, nil
+		}
+		prev = tracer
+	}
+}
@@ -0,0 +1,102 @@
+package simulator
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestEstimateGasAndAccessList(t *testing.T) {
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	router := common.HexToAddress("0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	pool := common.HexToAddress("0xcccccccccccccccccccccccccccccccccccccccc")
+
+	// router: sstore(0, sload(1)); if !call(gas, pool, 0, 0, 0, 0, 0) { revert }
+	// pool: sload(5)
+	routerCode := common.FromHex("600154600055" +
+		"6000600060006000600073" + strings.TrimPrefix(strings.ToLower(pool.Hex()), "0x") + "5af1" +
+		"15602c57" + "00" + "5b60006000fd")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, router, routerCode)
+		n.SetStorage(testBlock, router, common.BigToHash(big.NewInt(1)), common.HexToHash("0x2a"))
+		n.SetCode(testBlock, pool, common.FromHex("600554"+"50"+"00"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+	executor := NewExecutor(fork)
+	call := ethereum.CallMsg{From: sender, To: &router, GasPrice: big.NewInt(20e9)}
+
+	gas, err := executor.EstimateGas(call, block)
+	if err != nil {
+		t.Fatalf("EstimateGas: %v", err)
+	}
+	// the estimate is the lowest limit that works
+	call.Gas = gas - 1
+	if _, err := executor.EstimateGas(call, block); err == nil {
+		t.Errorf("call succeeded with %d gas, below the estimate %d", gas-1, gas)
+	}
+	call.Gas = 0
+
+	// estimation leaves no trace on the fork
+	if v, _ := fork.GetStorageAt(router, common.Hash{}); v != (common.Hash{}) {
+		t.Errorf("router slot 0 = %s after estimation", v.Hex())
+	}
+	if n, _ := fork.GetNonce(sender); n != 0 {
+		t.Errorf("sender nonce = %d after estimation", n)
+	}
+
+	result, err := executor.CreateAccessList(call, block)
+	if err != nil {
+		t.Fatalf("CreateAccessList: %v", err)
+	}
+	if result.RevertReason != "" {
+		t.Fatalf("call failed: %s", result.RevertReason)
+	}
+	slots := make(map[common.Address][]common.Hash)
+	for _, tuple := range result.AccessList {
+		slots[tuple.Address] = tuple.StorageKeys
+	}
+	if keys, ok := slots[pool]; !ok || len(keys) != 1 || keys[0] != common.BigToHash(big.NewInt(5)) {
+		t.Errorf("pool entry = %v, want slot 5 (list %v)", keys, result.AccessList)
+	}
+	if len(slots[router]) != 2 {
+		t.Errorf("router slots = %v, want 0 and 1", slots[router])
+	}
+	if _, ok := slots[sender]; ok {
+		t.Error("sender is in the access list")
+	}
+
+	// the estimate with the list applied covers what the list run used
+	call.AccessList = result.AccessList
+	withList, err := executor.EstimateGas(call, block)
+	if err != nil {
+		t.Fatalf("EstimateGas with access list: %v", err)
+	}
+	if withList < result.GasUsed {
+		t.Errorf("estimate with access list %d, below gas used %d", withList, result.GasUsed)
+	}
+}
+
+func TestEstimateGasReverts(t *testing.T) {
+	sender := common.HexToAddress("0x1234567890123456789012345678901234567890")
+	contract := common.HexToAddress("0xdddddddddddddddddddddddddddddddddddddddd")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetCode(testBlock, contract, common.FromHex("60006000fd"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	// no gas price: the sender needs no funds, like eth_estimateGas
+	_, err := NewExecutor(fork).EstimateGas(ethereum.CallMsg{From: sender, To: &contract}, block)
+	if err == nil || !strings.Contains(err.Error(), "execution reverted") {
+		t.Errorf("err = %v, want execution reverted", err)
+	}
+}
@@ -1,10 +1,15 @@
 package simulator
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip4844"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/tracing"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/params"
@@ -13,6 +18,11 @@ import (
 type Executor struct {
 	fork   *StateFork
 	config *params.ChainConfig
+	tracer *tracing.Hooks
+	trackChanges bool
+
+	// first failed BLOCKHASH lookup of the current execution
+	hashErr error
 }
 
 func NewExecutor(fork *StateFork) *Executor {
@@ -22,69 +32,125 @@ func NewExecutor(fork *StateFork) *Executor {
 	}
 }
 
+// SetTracer attaches EVM tracing hooks to every following ExecuteTransaction.
+// See NewTracer for the built-in ones; pass nil to stop tracing.
+func (e *Executor) SetTracer(hooks *tracing.Hooks) {
+	e.tracer = hooks
+}
+
+// SetTrackChanges turns on the state diff of every following tx, in
+// SimulationResult.StateChanges. It's off by default as recording the
+// pre-state of everything a tx touches can take extra state fetches.
+func (e *Executor) SetTrackChanges(on bool) {
+	e.trackChanges = on
+}
+
+// ExecOptions relax the checks of ExecuteTransactionWithOptions, for "what if"
+// runs of txs that were never signed or sent
+type ExecOptions struct {
+	From           *common.Address // sender to use instead of recovering the signature
+	SkipNonceCheck bool            // accept any tx nonce, the account nonce is still bumped
+	NoBaseFee      bool            // accept zero gas price txs, like eth_call
+}
+
 func (e *Executor) ExecuteTransaction(tx *types.Transaction, targetBlock *types.Block) (*SimulationResult, error) {
-	// Create state database wrapper
-	stateDB := NewForkedStateDB(e.fork)
+	return e.ExecuteTransactionWithOptions(tx, targetBlock, ExecOptions{})
+}
 
-	// Build block context from target block
-	block := e.fork.BlockContext()
-	blockContext := vm.BlockContext{
-		CanTransfer: core.CanTransfer,
-		Transfer:    core.Transfer,
-		GetHash:     func(n uint64) common.Hash { return common.Hash{} },
-		Coinbase:    targetBlock.Coinbase(),
-		BlockNumber: targetBlock.Number(),
-		Time:        targetBlock.Time(),
-		Difficulty:  targetBlock.Difficulty(),
-		GasLimit:    targetBlock.GasLimit(),
-		BaseFee:     targetBlock.BaseFee(),
-	}
-
-	// Extract sender from transaction
-	signer := types.LatestSignerForChainID(tx.ChainId())
-	sender, err := types.Sender(signer, tx)
+// ExecuteTransactionWithOptions is ExecuteTransaction with some consensus
+// checks turned off
+func (e *Executor) ExecuteTransactionWithOptions(tx *types.Transaction, targetBlock *types.Block, opts ExecOptions) (*SimulationResult, error) {
+	// Build message from transaction. This recovers the sender and carries the
+	// blob hashes, blob fee cap and EIP-7702 authorizations along
+	var signer types.Signer = types.LatestSignerForChainID(tx.ChainId())
+	if opts.From != nil {
+		signer = impersonatedSigner{Signer: signer, from: *opts.From}
+	}
+	msg, err := core.TransactionToMessage(tx, signer, targetBlock.BaseFee())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get sender: %w", err)
 	}
+	msg.SkipNonceChecks = opts.SkipNonceCheck
+	// an impersonated sender may well be a contract
+	msg.SkipTransactionChecks = opts.From != nil
 
-	// Initialize EVM
-	evm := vm.NewEVM(blockContext, stateDB, e.config, vm.Config{})
-	evm.SetTxContext(vm.TxContext{
-		Origin:   sender,
-		GasPrice: tx.GasPrice(),
-	})
+	return e.applyMessage(tx, msg, targetBlock, opts.NoBaseFee)
+}
 
-	// Take snapshot for potential revert
-	snap := stateDB.Snapshot()
+// ExecuteCall runs an unsigned call on top of the fork and keeps its state
+// changes, so calls and txs can be chained. The sender's current nonce is
+// used; without a gas price the call is free, like eth_call.
+func (e *Executor) ExecuteCall(call ethereum.CallMsg, targetBlock *types.Block) (*SimulationResult, error) {
+	msg, noBaseFee, err := e.callMessage(call, targetBlock)
+	if err != nil {
+		return nil, err
+	}
+	return e.applyMessage(callTransaction(msg), msg, targetBlock, noBaseFee)
+}
 
-	// Build message from transaction
-	msg := &core.Message{
-		To:         tx.To(),
-		From:       sender,
-		Nonce:      tx.Nonce(),
-		Value:      tx.Value(),
-		GasLimit:   tx.Gas(),
-		GasPrice:   tx.GasPrice(),
-		GasFeeCap:  tx.GasFeeCap(),
-		GasTipCap:  tx.GasTipCap(),
-		Data:       tx.Data(),
-		AccessList: tx.AccessList(),
+// applyMessage executes msg (built from tx) and keeps the result on the fork
+// unless the tx could not be included
+func (e *Executor) applyMessage(tx *types.Transaction, msg *core.Message, targetBlock *types.Block, noBaseFee bool) (*SimulationResult, error) {
+	// Create state database wrapper
+	stateDB := NewForkedStateDB(e.fork)
+	if e.trackChanges {
+		stateDB.tracker = NewStateTracker(e.fork)
 	}
+	stateDB.hooks = e.tracer
+	// transient storage must not leak into the next tx or a system call
+	defer e.fork.clearTransientStorage()
+	e.hashErr = nil
+
+	// Build block context from target block
+	blockContext := e.blockContext(targetBlock)
+	if noBaseFee {
+		lowerBaseFees(&blockContext, msg)
+	}
+	gasPrice := msg.GasPrice
 
 	// Validate intrinsic gas
-	_, err = core.IntrinsicGas(msg.Data, msg.AccessList, nil, msg.To == nil, true, true, true)
+	rules := e.config.Rules(targetBlock.Number(), blockContext.Random != nil, targetBlock.Time())
+	_, err := core.IntrinsicGas(msg.Data, msg.AccessList, msg.SetCodeAuthorizations, msg.To == nil, rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai)
 	if err != nil {
 		return nil, fmt.Errorf("intrinsic gas validation failed: %w", err)
 	}
 
-	// Execute transaction
-	gp := new(core.GasPool).AddGas(block.GasLimit())
+	// Initialize EVM
+	evm := vm.NewEVM(blockContext, stateDB, e.config, vm.Config{Tracer: e.tracer, NoBaseFee: noBaseFee})
+	evm.SetTxContext(core.NewEVMTxContext(msg))
+	if e.tracer != nil && e.tracer.OnTxStart != nil {
+		e.tracer.OnTxStart(evm.GetVMContext(), tx, msg.From)
+	}
+
+	// Take snapshot for potential revert
+	snap := stateDB.Snapshot()
+
+	coinbase := targetBlock.Coinbase()
+	coinbaseBefore := stateDB.GetBalance(coinbase).ToBig()
+
+	// Execute transaction. geth's state transition rejects fee caps below the
+	// base fee, buys gas at the effective price, burns the base fee part and
+	// pays the tip to the coinbase.
+	gp := new(core.GasPool).AddGas(targetBlock.GasLimit())
 	result, err := core.ApplyMessage(evm, msg, gp)
+	if e.hashErr != nil {
+		// the run saw a zero hash instead of the real one
+		stateDB.RevertToSnapshot(snap)
+		if e.tracer != nil && e.tracer.OnTxEnd != nil {
+			e.tracer.OnTxEnd(nil, e.hashErr)
+		}
+		return nil, fmt.Errorf("BLOCKHASH lookup failed: %w", e.hashErr)
+	}
 	if err != nil {
+		// consensus error: the tx could not be included, nothing is charged
 		stateDB.RevertToSnapshot(snap)
+		if e.tracer != nil && e.tracer.OnTxEnd != nil {
+			e.tracer.OnTxEnd(nil, err)
+		}
 		return &SimulationResult{
 			Success:      false,
 			RevertReason: err.Error(),
+			Err:          err,
 		}, nil
 	}
 
@@ -95,11 +161,150 @@ func (e *Executor) ExecuteTransaction(tx *types.Transaction, targetBlock *types.
 		ReturnData: result.ReturnData,
 		Logs:       stateDB.logs,
 	}
+	e.fillFees(simResult, gasPrice, blockContext.BaseFee)
+	if blobGas := uint64(len(msg.BlobHashes)) * params.BlobTxBlobGasPerBlob; blobGas > 0 {
+		simResult.BlobGasUsed = blobGas
+		simResult.BlobFees = new(big.Int).Mul(new(big.Int).SetUint64(blobGas), blockContext.BlobBaseFee)
+		simResult.BurntFees.Add(simResult.BurntFees, simResult.BlobFees)
+	}
+	simResult.CoinbaseDiff = new(big.Int).Sub(stateDB.GetBalance(coinbase).ToBig(), coinbaseBefore)
 
+	// a reverted tx is still included: the EVM already undid its call, but the
+	// nonce bump and gas payment stay, like on chain
 	if result.Failed() {
 		simResult.RevertReason = result.Err.Error()
-		stateDB.RevertToSnapshot(snap)
+		simResult.Err = result.Err
+		if errors.Is(result.Err, vm.ErrExecutionReverted) && len(result.Revert()) > 0 {
+			// same shape as a node's "execution reverted: <reason>"
+			simResult.RevertReason = fmt.Sprintf("%s: %s", result.Err, DecodeRevert(result.Revert()))
+		}
+		simResult.Logs = nil
+	}
+
+	// apply self-destructs and EIP-161 removals, then keep the changes but
+	// release this snapshot and the call frames' ones
+	stateDB.Finalise(true)
+	e.fork.discardSnapshot(snap)
+	if stateDB.tracker != nil {
+		simResult.StateChanges = stateDB.tracker.Changes()
+		simResult.tracker = stateDB.tracker
+	}
+
+	if e.tracer != nil && e.tracer.OnTxEnd != nil {
+		e.tracer.OnTxEnd(traceReceipt(tx, targetBlock, simResult), nil)
 	}
 
 	return simResult, nil
-}
\ No newline at end of file
+}
+
+// lowerBaseFees zeroes the block's base fees for free calls, as eth_call
+// does, so BASEFEE and the fee accounting agree with a zero gas price
+func lowerBaseFees(ctx *vm.BlockContext, msg *core.Message) {
+	if msg.GasPrice.Sign() == 0 && ctx.BaseFee != nil {
+		ctx.BaseFee = new(big.Int)
+	}
+	if msg.BlobGasFeeCap != nil && msg.BlobGasFeeCap.Sign() == 0 && ctx.BlobBaseFee != nil {
+		ctx.BlobBaseFee = new(big.Int)
+	}
+}
+
+// impersonatedSigner reports a fixed sender for any tx, so unsigned txs or
+// txs from accounts we don't hold keys for can be simulated
+type impersonatedSigner struct {
+	types.Signer
+	from common.Address
+}
+
+func (s impersonatedSigner) Sender(tx *types.Transaction) (common.Address, error) {
+	return s.from, nil
+}
+
+// Equal keeps types.Sender's cache from mixing real and impersonated senders
+func (s impersonatedSigner) Equal(other types.Signer) bool {
+	o, ok := other.(impersonatedSigner)
+	return ok && o.from == s.from && o.Signer.Equal(s.Signer)
+}
+
+// callTransaction is the unsigned tx handed to tracers for a call
+func callTransaction(msg *core.Message) *types.Transaction {
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:    params.MainnetChainConfig.ChainID,
+		Nonce:      msg.Nonce,
+		GasTipCap:  msg.GasTipCap,
+		GasFeeCap:  msg.GasFeeCap,
+		Gas:        msg.GasLimit,
+		To:         msg.To,
+		Value:      msg.Value,
+		Data:       msg.Data,
+		AccessList: msg.AccessList,
+	})
+}
+
+// traceReceipt is the receipt handed to OnTxEnd, tracers read the gas used
+// and status from it
+func traceReceipt(tx *types.Transaction, block *types.Block, r *SimulationResult) *types.Receipt {
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		Status:            types.ReceiptStatusFailed,
+		GasUsed:           r.GasUsed,
+		Logs:              r.Logs,
+		TxHash:            tx.Hash(),
+		EffectiveGasPrice: r.EffectiveGasPrice,
+		BlobGasUsed:       r.BlobGasUsed,
+		BlockNumber:       block.Number(),
+	}
+	if r.Success {
+		receipt.Status = types.ReceiptStatusSuccessful
+	}
+	return receipt
+}
+
+// fillFees splits what the sender paid for gas into the burnt base fee and the
+// priority fee credited to the coinbase
+func (e *Executor) fillFees(r *SimulationResult, gasPrice, baseFee *big.Int) {
+	gasUsed := new(big.Int).SetUint64(r.GasUsed)
+
+	r.EffectiveGasPrice = new(big.Int).Set(gasPrice)
+	r.PriorityFee = new(big.Int).Set(gasPrice)
+	r.BurntFees = new(big.Int)
+	if baseFee != nil {
+		r.PriorityFee.Sub(r.PriorityFee, baseFee)
+		r.BurntFees.Mul(gasUsed, baseFee)
+	}
+	r.GasFees = new(big.Int).Mul(gasUsed, r.PriorityFee)
+}
+
+// getHash is the EVM's BLOCKHASH lookup. A failed fetch resolves to the zero
+// hash and is kept in hashErr for the caller to report.
+func (e *Executor) getHash(num uint64) common.Hash {
+	hash, err := e.fork.GetBlockHash(num)
+	if err != nil && e.hashErr == nil {
+		e.hashErr = err
+	}
+	return hash
+}
+
+// blockContext builds the EVM block context for executing inside block
+func (e *Executor) blockContext(block *types.Block) vm.BlockContext {
+	ctx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     e.getHash, // the EVM only asks for the last 256 blocks
+		Coinbase:    block.Coinbase(),
+		BlockNumber: block.Number(),
+		Time:        block.Time(),
+		Difficulty:  block.Difficulty(),
+		GasLimit:    block.GasLimit(),
+		BaseFee:     block.BaseFee(),
+	}
+	if block.ExcessBlobGas() != nil {
+		ctx.BlobBaseFee = eip4844.CalcBlobFee(e.config, block.Header())
+	}
+	// post-merge blocks carry PREVRANDAO in the mix digest; geth only enables
+	// Shanghai+ rules (PUSH0, ...) when Random is set
+	if block.Difficulty().Sign() == 0 {
+		random := block.MixDigest()
+		ctx.Random = &random
+	}
+	return ctx
+}
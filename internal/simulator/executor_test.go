@@ -0,0 +1,262 @@
+package simulator
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestFeeAccounting(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	reverter := common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, reverter, common.FromHex("60006000fd")) // revert(0, 0)
+		n.AddBlock(testBlock, nil, nil)
+	})
+	target := types.NewBlockWithHeader(node.Header(testBlock + 1))
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+
+	// base fee is 10 gwei, so the sender pays 12 gwei and 2 gwei go to the coinbase
+	tipCap, feeCap := big.NewInt(2e9), big.NewInt(30e9)
+	send := func(nonce uint64, to common.Address, gas uint64) *SimulationResult {
+		tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     nonce,
+			GasTipCap: tipCap,
+			GasFeeCap: feeCap,
+			Gas:       gas,
+			To:        &to,
+			Value:     big.NewInt(1e15),
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		result, err := NewExecutor(fork).ExecuteTransaction(tx, target)
+		if err != nil {
+			t.Fatalf("ExecuteTransaction: %v", err)
+		}
+		return result
+	}
+
+	result := send(0, recipient, 21000)
+	if !result.Success {
+		t.Fatalf("transfer failed: %s", result.RevertReason)
+	}
+	if result.EffectiveGasPrice.Cmp(big.NewInt(12e9)) != 0 || result.PriorityFee.Cmp(tipCap) != 0 {
+		t.Errorf("price = %s tip = %s, want 12 gwei and 2 gwei", result.EffectiveGasPrice, result.PriorityFee)
+	}
+	wantTip := new(big.Int).Mul(big.NewInt(21000), tipCap)
+	if result.CoinbaseDiff.Cmp(wantTip) != 0 || result.GasFees.Cmp(wantTip) != 0 {
+		t.Errorf("coinbase diff = %s gas fees = %s, want %s", result.CoinbaseDiff, result.GasFees, wantTip)
+	}
+	if want := new(big.Int).Mul(big.NewInt(21000), testnode.DefaultBaseFee); result.BurntFees.Cmp(want) != 0 {
+		t.Errorf("burnt fees = %s, want %s", result.BurntFees, want)
+	}
+
+	// a reverted tx is still included: it bumps the nonce and pays for its gas
+	before, _ := fork.GetBalance(sender)
+	result = send(1, reverter, 50000)
+	if result.Success {
+		t.Fatal("call to reverting contract succeeded")
+	}
+	if nonce, _ := fork.GetNonce(sender); nonce != 2 {
+		t.Errorf("sender nonce = %d, want 2", nonce)
+	}
+	after, _ := fork.GetBalance(sender)
+	paid := new(big.Int).Mul(new(big.Int).SetUint64(result.GasUsed), big.NewInt(12e9))
+	if spent := new(big.Int).Sub(before, after); spent.Cmp(paid) != 0 {
+		t.Errorf("reverted tx cost %s, want gas only (%s)", spent, paid)
+	}
+	if bal, _ := fork.GetBalance(reverter); bal.Sign() != 0 {
+		t.Errorf("reverted value transfer kept: %s", bal)
+	}
+	if len(result.Logs) != 0 {
+		t.Errorf("reverted tx kept %d logs", len(result.Logs))
+	}
+}
+
+// testnode timestamps put this block after Pectra (EIP-7702 enabled)
+const pragueBlock = 22_500_000
+
+func TestBlobTransaction(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	// sstore(0, blobhash(0)); sstore(1, blobbasefee)
+	code := common.FromHex("600049600055" + "4a600155" + "00")
+
+	node, fork := newTestFork(t, cancunBlock, func(n *testnode.Node) {
+		n.SetBalance(cancunBlock, sender, big.NewInt(1e18))
+		n.SetCode(cancunBlock, contract, code)
+		n.AddBlock(cancunBlock, nil, nil)
+	})
+	target := types.NewBlockWithHeader(node.Header(cancunBlock + 1))
+
+	blobHash := common.HexToHash("0x01" + strings.Repeat("ab", 31)) // version byte 0x01
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.BlobTx{
+		ChainID:    uint256.NewInt(1),
+		GasTipCap:  uint256.NewInt(1e9),
+		GasFeeCap:  uint256.NewInt(30e9),
+		Gas:        100000,
+		To:         contract,
+		Value:      new(uint256.Int),
+		BlobFeeCap: uint256.NewInt(10),
+		BlobHashes: []common.Hash{blobHash},
+	})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	result, err := NewExecutor(fork).ExecuteTransaction(tx, target)
+	if err != nil {
+		t.Fatalf("ExecuteTransaction: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("blob tx failed: %s", result.RevertReason)
+	}
+	if got, _ := fork.GetStorageAt(contract, common.Hash{}); got != blobHash {
+		t.Errorf("blobhash(0) = %s, want %s", got.Hex(), blobHash.Hex())
+	}
+	// zero excess blob gas gives the minimum blob base fee of 1 wei
+	if got, _ := fork.GetStorageAt(contract, common.BigToHash(big.NewInt(1))); got != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("blobbasefee = %s, want 1", got.Hex())
+	}
+	if result.BlobGasUsed != params.BlobTxBlobGasPerBlob || result.BlobFees.Cmp(big.NewInt(params.BlobTxBlobGasPerBlob)) != 0 {
+		t.Errorf("blob gas = %d fees = %s, want %d at 1 wei", result.BlobGasUsed, result.BlobFees, params.BlobTxBlobGasPerBlob)
+	}
+
+	// the sender pays execution gas at 11 gwei plus the blob fee
+	spent := new(big.Int).Mul(new(big.Int).SetUint64(result.GasUsed), big.NewInt(11e9))
+	spent.Add(spent, result.BlobFees)
+	if bal, _ := fork.GetBalance(sender); new(big.Int).Sub(big.NewInt(1e18), bal).Cmp(spent) != 0 {
+		t.Errorf("sender paid %s, want %s", new(big.Int).Sub(big.NewInt(1e18), bal), spent)
+	}
+}
+
+func TestSetCodeTransaction(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	authKey, _ := crypto.GenerateKey()
+	authority := crypto.PubkeyToAddress(authKey.PublicKey)
+	delegate := common.HexToAddress("0x5555555555555555555555555555555555555555")
+
+	node, fork := newTestFork(t, pragueBlock, func(n *testnode.Node) {
+		n.SetBalance(pragueBlock, sender, big.NewInt(1e18))
+		n.SetCode(pragueBlock, delegate, common.FromHex("6001600055"+"00")) // sstore(0, 1)
+		n.AddBlock(pragueBlock, nil, nil)
+	})
+	target := types.NewBlockWithHeader(node.Header(pragueBlock + 1))
+
+	auth, err := types.SignSetCode(authKey, types.SetCodeAuthorization{
+		ChainID: *uint256.NewInt(1),
+		Address: delegate,
+		Nonce:   0,
+	})
+	if err != nil {
+		t.Fatalf("sign authorization: %v", err)
+	}
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.SetCodeTx{
+		ChainID:   uint256.NewInt(1),
+		GasTipCap: uint256.NewInt(1e9),
+		GasFeeCap: uint256.NewInt(30e9),
+		Gas:       100000,
+		To:        authority,
+		Value:     new(uint256.Int),
+		AuthList:  []types.SetCodeAuthorization{auth},
+	})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	result, err := NewExecutor(fork).ExecuteTransaction(tx, target)
+	if err != nil {
+		t.Fatalf("ExecuteTransaction: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("set-code tx failed: %s", result.RevertReason)
+	}
+
+	if code, _ := fork.GetCode(authority); !bytes.Equal(code, types.AddressToDelegation(delegate)) {
+		t.Errorf("authority code = %x, want delegation to %s", code, delegate.Hex())
+	}
+	if nonce, _ := fork.GetNonce(authority); nonce != 1 {
+		t.Errorf("authority nonce = %d, want 1", nonce)
+	}
+	// the call ran the delegate's code against the authority's storage
+	if got, _ := fork.GetStorageAt(authority, common.Hash{}); got != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("authority slot 0 = %s, want 1", got.Hex())
+	}
+}
+
+func TestWhatIfExecution(t *testing.T) {
+	whale := common.HexToAddress("0x4444444444444444444444444444444444444444")
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	counter := common.HexToAddress("0x5555555555555555555555555555555555555555")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, whale, big.NewInt(1e18))
+		n.SetNonce(testBlock, whale, 7)
+		// sstore(0, add(sload(0), 1)); return sload(0)
+		n.SetCode(testBlock, counter, common.FromHex("60005460010160005560005460005260206000f3"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+	executor := NewExecutor(fork)
+
+	// an unsigned call with no gas price commits like a tx
+	result, err := executor.ExecuteCall(ethereum.CallMsg{From: whale, To: &counter}, block)
+	if err != nil {
+		t.Fatalf("ExecuteCall: %v", err)
+	}
+	if !result.Success || new(big.Int).SetBytes(result.ReturnData).Int64() != 1 {
+		t.Fatalf("call: success=%v return=%x", result.Success, result.ReturnData)
+	}
+	if v, _ := fork.GetStorageAt(counter, common.Hash{}); v != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("counter slot 0 = %s, want 1", v.Hex())
+	}
+	if n, _ := fork.GetNonce(whale); n != 8 {
+		t.Errorf("whale nonce = %d, want 8", n)
+	}
+
+	// an unsigned tx with a stale nonce, sent as the whale
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     0,
+		GasTipCap: big.NewInt(1e9),
+		GasFeeCap: big.NewInt(30e9),
+		Gas:       21000,
+		To:        &recipient,
+		Value:     big.NewInt(1e15),
+	})
+	result, err = executor.ExecuteTransactionWithOptions(tx, block, ExecOptions{From: &whale})
+	if err != nil || result.Success || !strings.Contains(result.RevertReason, "nonce too low") {
+		t.Errorf("stale nonce without SkipNonceCheck: err=%v result=%+v", err, result)
+	}
+	result, err = executor.ExecuteTransactionWithOptions(tx, block, ExecOptions{From: &whale, SkipNonceCheck: true})
+	if err != nil {
+		t.Fatalf("ExecuteTransactionWithOptions: %v", err)
+	}
+	if !result.Success {
+		t.Fatalf("transfer failed: %s", result.RevertReason)
+	}
+	if bal, _ := fork.GetBalance(recipient); bal.Cmp(big.NewInt(1e15)) != 0 {
+		t.Errorf("recipient balance = %s, want 1e15", bal)
+	}
+	if n, _ := fork.GetNonce(whale); n != 9 {
+		t.Errorf("whale nonce = %d, want 9", n)
+	}
+}
@@ -14,8 +14,11 @@ import (
 	"github.com/pulkyeet/mev-searcher/internal/storage"
 )
 
+// DefaultCacheDBPath is where forks keep their persistent SQLite cache
+const DefaultCacheDBPath = "data/state_cache.db"
+
 type StateFork struct {
-	client      *eth.Client
+	client      eth.Backend
 	blockNumber *big.Int
 	block       *types.Block
 
@@ -23,6 +26,9 @@ type StateFork struct {
 	cache *StateCache
 	mu    sync.RWMutex
 
+	// set on child forks: cache misses read through to the parent
+	parent *StateFork
+
 	// Layer 2: LRU cache (recent executions)
 	lruBalance *lru.Cache[string, *big.Int]
 	lruNonce   *lru.Cache[string, uint64]
@@ -35,8 +41,12 @@ type StateFork struct {
 	// Stats
 	stats *CacheStats
 
-	// Snapshot for revert
-	snapshots []*StateCache
+	// Undo log for Snapshot/RevertToSnapshot
+	journal journal
+
+	// Canonical hashes for BLOCKHASH, immutable so shared across blocks
+	blockHashes   map[uint64]common.Hash
+	blockHashesMu sync.Mutex
 }
 
 type CacheStats struct {
@@ -47,7 +57,12 @@ type CacheStats struct {
 	BatchedRPCCalls int
 }
 
-func NewStateFork(client *eth.Client, blockNumber *big.Int) (*StateFork, error) {
+func NewStateFork(client eth.Backend, blockNumber *big.Int) (*StateFork, error) {
+	return NewStateForkWithCache(client, blockNumber, DefaultCacheDBPath)
+}
+
+// NewStateForkWithCache is NewStateFork with the SQLite cache at dbPath
+func NewStateForkWithCache(client eth.Backend, blockNumber *big.Int, dbPath string) (*StateFork, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -63,7 +78,7 @@ func NewStateFork(client *eth.Client, blockNumber *big.Int) (*StateFork, error)
 	lruStorage, _ := lru.New[string, common.Hash](50000)
 
 	// Initialize SQLite cache
-	db, err := storage.NewCacheDB("data/state_cache.db")
+	db, err := storage.NewCacheDB(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize cache db: %w", err)
 	}
@@ -79,10 +94,34 @@ func NewStateFork(client *eth.Client, blockNumber *big.Int) (*StateFork, error)
 		lruStorage:  lruStorage,
 		db:          db,
 		stats:       &CacheStats{},
-		snapshots:   make([]*StateCache, 0),
+		blockHashes: map[uint64]common.Hash{blockNumber.Uint64(): block.Hash()},
 	}, nil
 }
 
+// Child returns a copy-on-write fork of f's current state. Reads the child
+// hasn't written fall through to f (and so to the LRU, SQLite and RPC
+// layers), writes and snapshots stay private to the child. Children are
+// cheap and safe to use from different goroutines, e.g. one per candidate
+// bundle, as long as f itself is not written to or advanced while they live.
+func (f *StateFork) Child() *StateFork {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &StateFork{
+		client:      f.client,
+		blockNumber: f.blockNumber,
+		block:       f.block,
+		cache:       NewStateCache(),
+		parent:      f,
+		lruBalance:  f.lruBalance,
+		lruNonce:    f.lruNonce,
+		lruCode:     f.lruCode,
+		lruStorage:  f.lruStorage,
+		db:          f.db,
+		stats:       f.stats,
+		blockHashes: make(map[uint64]common.Hash),
+	}
+}
+
 // Cache key helpers
 func balanceKey(block uint64, addr common.Address) string {
 	return fmt.Sprintf("%d:%s", block, addr.Hex())
@@ -102,6 +141,17 @@ func (f *StateFork) GetBalance(addr common.Address) (*big.Int, error) {
 	}
 	f.mu.RUnlock()
 
+	if f.parent != nil {
+		bal, err := f.parent.GetBalance(addr)
+		if err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		f.cache.balances[addr] = bal
+		f.mu.Unlock()
+		return new(big.Int).Set(bal), nil
+	}
+
 	blockNum := f.blockNumber.Uint64()
 	key := balanceKey(blockNum, addr)
 
@@ -166,6 +216,17 @@ func (f *StateFork) GetNonce(addr common.Address) (uint64, error) {
 	}
 	f.mu.RUnlock()
 
+	if f.parent != nil {
+		nonce, err := f.parent.GetNonce(addr)
+		if err != nil {
+			return 0, err
+		}
+		f.mu.Lock()
+		f.cache.nonces[addr] = nonce
+		f.mu.Unlock()
+		return nonce, nil
+	}
+
 	blockNum := f.blockNumber.Uint64()
 	key := balanceKey(blockNum, addr) // Reuse same key format
 
@@ -229,6 +290,17 @@ func (f *StateFork) GetCode(addr common.Address) ([]byte, error) {
 	}
 	f.mu.RUnlock()
 
+	if f.parent != nil {
+		code, err := f.parent.GetCode(addr)
+		if err != nil {
+			return nil, err
+		}
+		f.mu.Lock()
+		f.cache.code[addr] = code
+		f.mu.Unlock()
+		return code, nil
+	}
+
 	blockNum := f.blockNumber.Uint64()
 	key := balanceKey(blockNum, addr)
 
@@ -292,8 +364,27 @@ func (f *StateFork) GetStorageAt(addr common.Address, slot common.Hash) (common.
 			return val, nil
 		}
 	}
+	// account was recreated or deleted, whatever the backend holds is gone
+	if f.cache.cleared[addr] {
+		f.mu.RUnlock()
+		return common.Hash{}, nil
+	}
 	f.mu.RUnlock()
 
+	if f.parent != nil {
+		val, err := f.parent.GetStorageAt(addr, slot)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		f.mu.Lock()
+		if f.cache.storage[addr] == nil {
+			f.cache.storage[addr] = make(map[common.Hash]common.Hash)
+		}
+		f.cache.storage[addr][slot] = val
+		f.mu.Unlock()
+		return val, nil
+	}
+
 	blockNum := f.blockNumber.Uint64()
 	key := storageKey(blockNum, addr, slot)
 
@@ -465,73 +556,160 @@ func (f *StateFork) PrewarmFromTrace(txHash common.Hash) error {
 	return nil
 }
 
-// Setters remain unchanged
+// GetBlockHash resolves a canonical block hash for BLOCKHASH: memory, then
+// SQLite, then a header fetch. The fetch runs without holding the lock.
+func (f *StateFork) GetBlockHash(num uint64) (common.Hash, error) {
+	f.blockHashesMu.Lock()
+	hash, ok := f.blockHashes[num]
+	f.blockHashesMu.Unlock()
+	if ok {
+		return hash, nil
+	}
+	if f.parent != nil {
+		return f.parent.GetBlockHash(num)
+	}
+
+	if hash, ok := f.db.GetBlockHash(num); ok {
+		f.stats.mu.Lock()
+		f.stats.SQLiteHits++
+		f.stats.mu.Unlock()
+
+		return f.cacheBlockHash(num, hash), nil
+	}
+
+	f.stats.mu.Lock()
+	f.stats.RPCCalls++
+	f.stats.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	header, err := f.client.HeaderByNumber(ctx, new(big.Int).SetUint64(num))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to fetch header %d: %w", num, err)
+	}
+
+	hash = header.Hash()
+	f.db.SetBlockHash(num, hash)
+	return f.cacheBlockHash(num, hash), nil
+}
+
+// cacheBlockHash keeps a looked up hash in memory, unless SetBlockHash got
+// there first while the lock was released
+func (f *StateFork) cacheBlockHash(num uint64, hash common.Hash) common.Hash {
+	f.blockHashesMu.Lock()
+	defer f.blockHashesMu.Unlock()
+	if set, ok := f.blockHashes[num]; ok {
+		return set
+	}
+	f.blockHashes[num] = hash
+	return hash
+}
+
+// SetBlockHash overrides what BLOCKHASH returns for num, for blocks built on
+// top of the fork that the backend doesn't know about. Not persisted.
+func (f *StateFork) SetBlockHash(num uint64, hash common.Hash) {
+	f.blockHashesMu.Lock()
+	defer f.blockHashesMu.Unlock()
+	f.blockHashes[num] = hash
+}
+
+// Setters record the previous value in the journal so snapshots can undo them
 func (f *StateFork) SetBalance(addr common.Address, bal *big.Int) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	prev, existed := f.cache.balances[addr]
+	f.journal.append(balanceChange{addr: addr, prev: prev, existed: existed})
 	f.cache.balances[addr] = new(big.Int).Set(bal)
 }
 
 func (f *StateFork) SetNonce(addr common.Address, nonce uint64) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	prev, existed := f.cache.nonces[addr]
+	f.journal.append(nonceChange{addr: addr, prev: prev, existed: existed})
 	f.cache.nonces[addr] = nonce
 }
 
+func (f *StateFork) SetCode(addr common.Address, code []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prev, existed := f.cache.code[addr]
+	f.journal.append(codeChange{addr: addr, prev: prev, existed: existed})
+	f.cache.code[addr] = code
+}
+
 func (f *StateFork) SetStorageAt(addr common.Address, slot common.Hash, val common.Hash) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	if f.cache.storage[addr] == nil {
 		f.cache.storage[addr] = make(map[common.Hash]common.Hash)
 	}
+	prev, existed := f.cache.storage[addr][slot]
+	f.journal.append(storageChange{addr: addr, slot: slot, prev: prev, existed: existed})
 	f.cache.storage[addr][slot] = val
 }
 
-// Snapshot/revert unchanged
-func (f *StateFork) Snapshot() int {
+// Transient storage (EIP-1153) is journaled like regular writes so call-frame
+// reverts undo TSTOREs, and is dropped at the end of every transaction
+func (f *StateFork) GetTransientState(addr common.Address, slot common.Hash) common.Hash {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cache.transient[addr][slot]
+}
+
+func (f *StateFork) SetTransientState(addr common.Address, slot common.Hash, val common.Hash) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-
-	snap := &StateCache{
-		balances: make(map[common.Address]*big.Int),
-		nonces:   make(map[common.Address]uint64),
-		code:     make(map[common.Address][]byte),
-		storage:  make(map[common.Address]map[common.Hash]common.Hash),
-	}
-
-	for addr, bal := range f.cache.balances {
-		snap.balances[addr] = new(big.Int).Set(bal)
-	}
-	for addr, nonce := range f.cache.nonces {
-		snap.nonces[addr] = nonce
+	prev := f.cache.transient[addr][slot]
+	if prev == val {
+		return
 	}
-	for addr, code := range f.cache.code {
-		snap.code[addr] = code
+	f.journal.append(transientChange{addr: addr, slot: slot, prev: prev})
+	if f.cache.transient[addr] == nil {
+		f.cache.transient[addr] = make(map[common.Hash]common.Hash)
 	}
-	for addr, slots := range f.cache.storage {
-		snap.storage[addr] = make(map[common.Hash]common.Hash)
-		for slot, val := range slots {
-			snap.storage[addr][slot] = val
-		}
+	f.cache.transient[addr][slot] = val
+}
+
+// clearTransientStorage wipes transient storage between transactions
+func (f *StateFork) clearTransientStorage() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.cache.transient) > 0 {
+		f.cache.transient = make(map[common.Address]map[common.Hash]common.Hash)
 	}
+}
 
-	f.snapshots = append(f.snapshots, snap)
-	return len(f.snapshots) - 1
+// Snapshot marks the current journal position, O(1)
+func (f *StateFork) Snapshot() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.journal.snapshot()
 }
 
+// RevertToSnapshot undoes every write since snapID and drops snapID and all
+// later snapshots
 func (f *StateFork) RevertToSnapshot(snapID int) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if snapID < 0 || snapID >= len(f.snapshots) {
+	if snapID < 0 || snapID >= len(f.journal.revisions) {
 		return fmt.Errorf("invalid snapshot id: %d", snapID)
 	}
 
-	f.cache = f.snapshots[snapID]
-	f.snapshots = f.snapshots[:snapID]
+	f.journal.revert(f.cache, snapID)
 	return nil
 }
 
+// discardSnapshot forgets snapID and all later snapshots while keeping their
+// changes. Once no snapshot is left the journal is emptied.
+func (f *StateFork) discardSnapshot(snapID int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.journal.discard(snapID)
+}
+
 func (f *StateFork) BlockContext() *types.Block {
 	return f.block
 }
@@ -561,7 +739,11 @@ func (f *StateFork) PrintStats() {
 	fmt.Printf("Total:        %d\n\n", total)
 }
 
+// Close releases the SQLite cache. Children share their root's, closing one is a no-op.
 func (f *StateFork) Close() error {
+	if f.parent != nil {
+		return nil
+	}
 	if f.db != nil {
 		return f.db.Close()
 	}
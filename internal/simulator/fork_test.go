@@ -0,0 +1,360 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/eth"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+const testBlock = 18_000_000
+
+// newTestFork starts a node, lets seed populate it and forks at block with a
+// throwaway SQLite cache
+func newTestFork(t *testing.T, block uint64, seed func(n *testnode.Node)) (*testnode.Node, *StateFork) {
+	t.Helper()
+
+	node := testnode.New()
+	t.Cleanup(node.Close)
+	seed(node)
+
+	client, err := eth.DialClient(node.URL())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	fork, err := NewStateForkWithCache(client, new(big.Int).SetUint64(block), filepath.Join(t.TempDir(), "state_cache.db"))
+	if err != nil {
+		t.Fatalf("NewStateFork: %v", err)
+	}
+	t.Cleanup(func() { fork.Close() })
+	return node, fork
+}
+
+func TestForkReadsAndReverts(t *testing.T) {
+	addr := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	slot := common.HexToHash("0x05")
+
+	_, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, addr, big.NewInt(42))
+		n.SetNonce(testBlock, addr, 7)
+		n.SetCode(testBlock, addr, []byte{0x00})
+		n.SetStorage(testBlock, addr, slot, common.HexToHash("0xbeef"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+
+	if bal, err := fork.GetBalance(addr); err != nil || bal.Int64() != 42 {
+		t.Errorf("GetBalance = %v, %v", bal, err)
+	}
+	if nonce, err := fork.GetNonce(addr); err != nil || nonce != 7 {
+		t.Errorf("GetNonce = %d, %v", nonce, err)
+	}
+	if code, err := fork.GetCode(addr); err != nil || len(code) != 1 {
+		t.Errorf("GetCode = %x, %v", code, err)
+	}
+	if val, err := fork.GetStorageAt(addr, slot); err != nil || val != common.HexToHash("0xbeef") {
+		t.Errorf("GetStorageAt = %s, %v", val.Hex(), err)
+	}
+
+	snap := fork.Snapshot()
+	fork.SetBalance(addr, big.NewInt(1))
+	fork.SetStorageAt(addr, slot, common.Hash{})
+	if err := fork.RevertToSnapshot(snap); err != nil {
+		t.Fatalf("RevertToSnapshot: %v", err)
+	}
+	if bal, _ := fork.GetBalance(addr); bal.Int64() != 42 {
+		t.Errorf("balance after revert = %s, want 42", bal)
+	}
+	if val, _ := fork.GetStorageAt(addr, slot); val != common.HexToHash("0xbeef") {
+		t.Errorf("storage after revert = %s", val.Hex())
+	}
+}
+
+func TestExecuteTransfer(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.AddBlock(testBlock, nil, nil)
+	})
+
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+		GasPrice: big.NewInt(20e9),
+		Gas:      21000,
+		To:       &recipient,
+		Value:    big.NewInt(1e17),
+	})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	target := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	result, err := NewExecutor(fork).ExecuteTransaction(tx, target)
+	if err != nil {
+		t.Fatalf("ExecuteTransaction: %v", err)
+	}
+	if !result.Success || result.GasUsed != 21000 {
+		t.Fatalf("unexpected result: success=%v gas=%d reason=%q", result.Success, result.GasUsed, result.RevertReason)
+	}
+
+	if bal, _ := fork.GetBalance(recipient); bal.Cmp(big.NewInt(1e17)) != 0 {
+		t.Errorf("recipient balance = %s, want 1e17", bal)
+	}
+	if nonce, _ := fork.GetNonce(sender); nonce != 1 {
+		t.Errorf("sender nonce = %d, want 1", nonce)
+	}
+	// value + 21000 gas at the legacy gas price
+	spent := new(big.Int).Mul(big.NewInt(21000), big.NewInt(20e9))
+	spent.Add(spent, big.NewInt(1e17))
+	want := new(big.Int).Sub(big.NewInt(1e18), spent)
+	if bal, _ := fork.GetBalance(sender); bal.Cmp(want) != 0 {
+		t.Errorf("sender balance = %s, want %s", bal, want)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	validator := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	transfer := func(nonce uint64) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: big.NewInt(20e9),
+			Gas:      21000,
+			To:       &recipient,
+			Value:    big.NewInt(1e15),
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return tx
+	}
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.AddBlock(testBlock, nil, nil)
+		n.AddBlockWithBody(n.Header(testBlock+1), &types.Body{
+			Transactions: []*types.Transaction{transfer(0), transfer(1)},
+			Withdrawals:  []*types.Withdrawal{{Index: 1, Validator: 7, Address: validator, Amount: 32}},
+		}, nil)
+	})
+
+	result, err := fork.Advance()
+	if err != nil {
+		t.Fatalf("Advance: %v", err)
+	}
+	if result.BlockNumber != testBlock+1 || len(result.Transactions) != 2 || result.GasUsed != 42000 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if fork.BlockContext().NumberU64() != testBlock+1 {
+		t.Errorf("fork still at block %d", fork.BlockContext().NumberU64())
+	}
+	if bal, _ := fork.GetBalance(recipient); bal.Cmp(big.NewInt(2e15)) != 0 {
+		t.Errorf("recipient balance = %s, want 2e15", bal)
+	}
+	if bal, _ := fork.GetBalance(validator); bal.Cmp(big.NewInt(32e9)) != 0 {
+		t.Errorf("withdrawal credited %s wei, want 32 gwei", bal)
+	}
+
+	// a block whose receipts disagree with the simulation leaves the fork alone
+	receipts := []*types.Receipt{{Status: types.ReceiptStatusSuccessful, GasUsed: 30000}}
+	node.AddBlock(testBlock+2, []*types.Transaction{transfer(2)}, receipts)
+
+	result, err = fork.Advance()
+	if !errors.Is(err, ErrStateDiverged) {
+		t.Fatalf("expected ErrStateDiverged, got %v", err)
+	}
+	if len(result.Mismatches) != 2 || result.Mismatches[0].Field != "gasUsed" {
+		t.Errorf("unexpected mismatches: %v", result.Mismatches)
+	}
+	if fork.BlockContext().NumberU64() != testBlock+1 {
+		t.Errorf("fork moved to %d after a diverged block", fork.BlockContext().NumberU64())
+	}
+	if nonce, _ := fork.GetNonce(sender); nonce != 2 {
+		t.Errorf("sender nonce = %d after revert, want 2", nonce)
+	}
+}
+
+func TestApplyBlockReportsLogDivergence(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	emitter := common.HexToAddress("0x5555555555555555555555555555555555555555")
+
+	// mstore(0, 0xaa); log1(0, 32, topic=1)
+	code := common.FromHex("60aa600052" + "6001" + "6020" + "6000" + "a1" + "00")
+
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+		GasPrice: big.NewInt(20e9),
+		Gas:      50000,
+		To:       &emitter,
+	})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, emitter, code)
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1)).WithBody(types.Body{Transactions: []*types.Transaction{tx}})
+
+	// on-chain receipt claims a different topic and gas
+	receipt := &types.Receipt{
+		Status:            types.ReceiptStatusSuccessful,
+		GasUsed:           21000,
+		CumulativeGasUsed: 21000,
+		Logs: []*types.Log{{
+			Address: emitter,
+			Topics:  []common.Hash{common.HexToHash("0x02")},
+			Data:    common.LeftPadBytes([]byte{0xaa}, 32),
+		}},
+	}
+
+	result, err := fork.ApplyBlock(block, []*types.Receipt{receipt})
+	if err != nil {
+		t.Fatalf("ApplyBlock: %v", err)
+	}
+	fields := map[string]bool{}
+	for _, m := range result.Mismatches {
+		fields[m.Field] = true
+	}
+	for _, want := range []string{"gasUsed", "cumulativeGasUsed", "logs[0].topics"} {
+		if !fields[want] {
+			t.Errorf("missing %s mismatch, got %v", want, result.Mismatches)
+		}
+	}
+	if fields["logCount"] || fields["logs[0].data"] || fields["status"] {
+		t.Errorf("unexpected mismatches: %v", result.Mismatches)
+	}
+}
+
+func TestBlockHash(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	oracle := common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	// sstore(0, blockhash(testBlock-1))
+	code := common.FromHex(fmt.Sprintf("63%08x", testBlock-1) + "40" + "600055" + "00")
+
+	var parent *types.Block
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, oracle, code)
+		parent = n.AddBlock(testBlock-1, nil, nil)
+		n.AddBlock(testBlock, nil, nil)
+	})
+
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+		GasPrice: big.NewInt(20e9),
+		Gas:      100000,
+		To:       &oracle,
+	})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	target := types.NewBlockWithHeader(node.Header(testBlock + 1))
+	if result, err := NewExecutor(fork).ExecuteTransaction(tx, target); err != nil || !result.Success {
+		t.Fatalf("tx failed: %v %+v", err, result)
+	}
+
+	if v, _ := fork.GetStorageAt(oracle, common.Hash{}); v != parent.Hash() {
+		t.Errorf("blockhash(%d) = %s, want %s", testBlock-1, v.Hex(), parent.Hash().Hex())
+	}
+
+	// the hash is cached, a second lookup doesn't hit the backend
+	calls := fork.GetStats().RPCCalls
+	if hash, err := fork.GetBlockHash(testBlock - 1); err != nil || hash != parent.Hash() || fork.GetStats().RPCCalls != calls {
+		t.Errorf("cached block hash lookup went to the backend")
+	}
+
+	// a block the backend can't serve is an error, not a zero hash
+	if hash, err := fork.GetBlockHash(testBlock + 100); err == nil {
+		t.Errorf("unknown block hash = %s, want an error", hash.Hex())
+	}
+}
+
+func TestChildForks(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	pool := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	slot := common.HexToHash("0x08")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetStorage(testBlock, pool, slot, common.HexToHash("0x01"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	target := types.NewBlockWithHeader(node.Header(testBlock + 1))
+	// parent state the children start from
+	fork.SetStorageAt(pool, slot, common.HexToHash("0x02"))
+
+	const children = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, children)
+	for i := 0; i < children; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child := fork.Child()
+			defer child.Close()
+
+			if v, _ := child.GetStorageAt(pool, slot); v != common.HexToHash("0x02") {
+				errs <- fmt.Errorf("child %d: slot = %s before writing, want parent's 0x02", i, v.Hex())
+				return
+			}
+			child.SetStorageAt(pool, slot, common.BigToHash(big.NewInt(int64(100+i))))
+
+			// every child sends the same nonce 0 tx to its own recipient
+			recipient := common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+			tx, _ := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+				GasPrice: big.NewInt(20e9),
+				Gas:      21000,
+				To:       &recipient,
+				Value:    big.NewInt(1e15),
+			})
+			result, err := NewExecutor(child).ExecuteTransaction(tx, target)
+			if err != nil || !result.Success {
+				errs <- fmt.Errorf("child %d: tx failed: %v %+v", i, err, result)
+				return
+			}
+			if v, _ := child.GetStorageAt(pool, slot); v != common.BigToHash(big.NewInt(int64(100+i))) {
+				errs <- fmt.Errorf("child %d: slot = %s, want its own write", i, v.Hex())
+			}
+			if n, _ := child.GetNonce(sender); n != 1 {
+				errs <- fmt.Errorf("child %d: sender nonce = %d, want 1", i, n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// the parent saw none of it, and closed children left the shared db open
+	if v, _ := fork.GetStorageAt(pool, slot); v != common.HexToHash("0x02") {
+		t.Errorf("parent slot = %s, want 0x02", v.Hex())
+	}
+	if n, _ := fork.GetNonce(sender); n != 0 {
+		t.Errorf("parent sender nonce = %d, want 0", n)
+	}
+	if bal, err := fork.GetBalance(common.BigToAddress(big.NewInt(0x1000))); err != nil || bal.Sign() != 0 {
+		t.Errorf("parent recipient balance = %v, %v", bal, err)
+	}
+	if _, err := fork.Child().Advance(); err == nil {
+		t.Error("child fork advanced")
+	}
+}
@@ -0,0 +1,137 @@
+package simulator
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// journalEntry undoes one write to the execution cache
+type journalEntry interface {
+	revert(c *StateCache)
+}
+
+// journal records writes to the execution cache while snapshots are
+// outstanding. A snapshot is just a position in the journal, so taking one is
+// O(1) and reverting costs O(writes since the snapshot).
+type journal struct {
+	entries   []journalEntry
+	revisions []int // journal length at each snapshot
+}
+
+func (j *journal) append(e journalEntry) {
+	// nothing can be reverted without a snapshot, don't keep history
+	if len(j.revisions) == 0 {
+		return
+	}
+	j.entries = append(j.entries, e)
+}
+
+func (j *journal) snapshot() int {
+	j.revisions = append(j.revisions, len(j.entries))
+	return len(j.revisions) - 1
+}
+
+// revert undoes everything recorded since snapshot id and drops id and
+// every later snapshot
+func (j *journal) revert(c *StateCache, id int) {
+	mark := j.revisions[id]
+	for i := len(j.entries) - 1; i >= mark; i-- {
+		j.entries[i].revert(c)
+		j.entries[i] = nil
+	}
+	j.entries = j.entries[:mark]
+	j.revisions = j.revisions[:id]
+}
+
+// discard forgets snapshot id and every later one, keeping their changes
+func (j *journal) discard(id int) {
+	if id < 0 || id >= len(j.revisions) {
+		return
+	}
+	j.revisions = j.revisions[:id]
+	if len(j.revisions) == 0 {
+		j.reset()
+	}
+}
+
+func (j *journal) reset() {
+	j.entries = j.entries[:0]
+	j.revisions = j.revisions[:0]
+}
+
+type balanceChange struct {
+	addr    common.Address
+	prev    *big.Int
+	existed bool
+}
+
+func (ch balanceChange) revert(c *StateCache) {
+	if ch.existed {
+		c.balances[ch.addr] = ch.prev
+	} else {
+		delete(c.balances, ch.addr)
+	}
+}
+
+type nonceChange struct {
+	addr    common.Address
+	prev    uint64
+	existed bool
+}
+
+func (ch nonceChange) revert(c *StateCache) {
+	if ch.existed {
+		c.nonces[ch.addr] = ch.prev
+	} else {
+		delete(c.nonces, ch.addr)
+	}
+}
+
+type codeChange struct {
+	addr    common.Address
+	prev    []byte
+	existed bool
+}
+
+func (ch codeChange) revert(c *StateCache) {
+	if ch.existed {
+		c.code[ch.addr] = ch.prev
+	} else {
+		delete(c.code, ch.addr)
+	}
+}
+
+type storageChange struct {
+	addr    common.Address
+	slot    common.Hash
+	prev    common.Hash
+	existed bool
+}
+
+func (ch storageChange) revert(c *StateCache) {
+	if ch.existed {
+		c.storage[ch.addr][ch.slot] = ch.prev
+	} else if slots := c.storage[ch.addr]; slots != nil {
+		delete(slots, ch.slot)
+	}
+}
+
+type transientChange struct {
+	addr common.Address
+	slot common.Hash
+	prev common.Hash
+}
+
+func (ch transientChange) revert(c *StateCache) {
+	if ch.prev == (common.Hash{}) {
+		if slots := c.transient[ch.addr]; slots != nil {
+			delete(slots, ch.slot)
+		}
+		return
+	}
+	if c.transient[ch.addr] == nil {
+		c.transient[ch.addr] = make(map[common.Hash]common.Hash)
+	}
+	c.transient[ch.addr][ch.slot] = ch.prev
+}
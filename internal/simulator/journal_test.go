@@ -0,0 +1,154 @@
+package simulator
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newCacheOnlyFork is a fork without a backend, enough for snapshot tests
+// that only touch the execution cache
+func newCacheOnlyFork() *StateFork {
+	return &StateFork{cache: NewStateCache(), stats: &CacheStats{}}
+}
+
+func TestJournalNestedRevert(t *testing.T) {
+	f := newCacheOnlyFork()
+	addr := common.HexToAddress("0x01")
+	slot := common.HexToHash("0x02")
+
+	f.SetBalance(addr, big.NewInt(1))
+	f.SetStorageAt(addr, slot, common.HexToHash("0xaa"))
+
+	outer := f.Snapshot()
+	f.SetBalance(addr, big.NewInt(2))
+	f.SetNonce(addr, 5)
+
+	inner := f.Snapshot()
+	f.SetStorageAt(addr, slot, common.HexToHash("0xbb"))
+	f.SetCode(addr, []byte{0x60})
+
+	if err := f.RevertToSnapshot(inner); err != nil {
+		t.Fatalf("revert inner: %v", err)
+	}
+	if f.cache.storage[addr][slot] != common.HexToHash("0xaa") {
+		t.Errorf("slot = %s after inner revert", f.cache.storage[addr][slot].Hex())
+	}
+	if _, ok := f.cache.code[addr]; ok {
+		t.Errorf("code written after inner snapshot survived the revert")
+	}
+	if f.cache.balances[addr].Int64() != 2 || f.cache.nonces[addr] != 5 {
+		t.Errorf("changes before inner snapshot were lost")
+	}
+
+	if err := f.RevertToSnapshot(outer); err != nil {
+		t.Fatalf("revert outer: %v", err)
+	}
+	if f.cache.balances[addr].Int64() != 1 {
+		t.Errorf("balance = %s after outer revert", f.cache.balances[addr])
+	}
+	if _, ok := f.cache.nonces[addr]; ok {
+		t.Errorf("nonce set after outer snapshot survived the revert")
+	}
+	if err := f.RevertToSnapshot(inner); err == nil {
+		t.Errorf("reverting to a dropped snapshot should fail")
+	}
+}
+
+func TestJournalDiscardKeepsChanges(t *testing.T) {
+	f := newCacheOnlyFork()
+	addr := common.HexToAddress("0x01")
+
+	outer := f.Snapshot()
+	f.SetBalance(addr, big.NewInt(1))
+	inner := f.Snapshot()
+	f.SetBalance(addr, big.NewInt(2))
+
+	f.discardSnapshot(inner)
+	if f.cache.balances[addr].Int64() != 2 {
+		t.Fatalf("discard changed state")
+	}
+	if err := f.RevertToSnapshot(outer); err != nil {
+		t.Fatalf("revert outer: %v", err)
+	}
+	if _, ok := f.cache.balances[addr]; ok {
+		t.Errorf("outer revert should undo writes made under a discarded snapshot")
+	}
+
+	f.Snapshot()
+	f.SetBalance(addr, big.NewInt(3))
+	f.discardSnapshot(0)
+	if len(f.journal.entries) != 0 {
+		t.Errorf("journal holds %d entries with no snapshot outstanding", len(f.journal.entries))
+	}
+}
+
+// deepCopySnapshot is the snapshot strategy used before the journal: copy
+// the whole execution cache on every call
+func deepCopySnapshot(c *StateCache) *StateCache {
+	snap := NewStateCache()
+	for addr, bal := range c.balances {
+		snap.balances[addr] = new(big.Int).Set(bal)
+	}
+	for addr, nonce := range c.nonces {
+		snap.nonces[addr] = nonce
+	}
+	for addr, code := range c.code {
+		snap.code[addr] = code
+	}
+	for addr, slots := range c.storage {
+		snap.storage[addr] = make(map[common.Hash]common.Hash, len(slots))
+		for slot, val := range slots {
+			snap.storage[addr][slot] = val
+		}
+	}
+	return snap
+}
+
+// warmFork fills the cache like a large DeFi tx would: many accounts, each
+// with a handful of touched slots
+func warmFork(accounts, slots int) *StateFork {
+	f := newCacheOnlyFork()
+	for i := 0; i < accounts; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		f.cache.balances[addr] = big.NewInt(int64(i))
+		f.cache.nonces[addr] = uint64(i)
+		f.cache.storage[addr] = make(map[common.Hash]common.Hash, slots)
+		for j := 0; j < slots; j++ {
+			f.cache.storage[addr][common.BigToHash(big.NewInt(int64(j)))] = common.BigToHash(big.NewInt(int64(i * j)))
+		}
+	}
+	return f
+}
+
+// Each iteration models one call frame: snapshot, a few writes, revert
+func BenchmarkSnapshotRevert(b *testing.B) {
+	for _, size := range []int{100, 1000, 5000} {
+		addr := common.BigToAddress(big.NewInt(1))
+		slot := common.BigToHash(big.NewInt(1))
+
+		b.Run(fmt.Sprintf("journal/%d", size), func(b *testing.B) {
+			f := warmFork(size, 10)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := f.Snapshot()
+				f.SetBalance(addr, big.NewInt(int64(i)))
+				f.SetStorageAt(addr, slot, common.Hash{})
+				f.RevertToSnapshot(id)
+			}
+		})
+
+		b.Run(fmt.Sprintf("deepcopy/%d", size), func(b *testing.B) {
+			f := warmFork(size, 10)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap := deepCopySnapshot(f.cache)
+				f.cache.balances[addr] = big.NewInt(int64(i))
+				f.cache.storage[addr][slot] = common.Hash{}
+				f.cache = snap
+			}
+		})
+	}
+}
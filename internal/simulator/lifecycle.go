@@ -0,0 +1,131 @@
+package simulator
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Account lifecycle follows geth's Cancun rules: flags set during a tx are
+// journaled so call-frame reverts undo them, and finaliseTx applies them at
+// the end of the tx (self-destructs, EIP-161 empty account removal).
+
+type lifecycleFlag int
+
+const (
+	flagCreated lifecycleFlag = iota
+	flagDestructed
+	flagTouched
+)
+
+func (c *StateCache) flags(flag lifecycleFlag) map[common.Address]bool {
+	switch flag {
+	case flagCreated:
+		return c.created
+	case flagDestructed:
+		return c.destructed
+	default:
+		return c.touched
+	}
+}
+
+type lifecycleChange struct {
+	flag lifecycleFlag
+	addr common.Address
+}
+
+// flags only ever go from unset to set within a tx
+func (ch lifecycleChange) revert(c *StateCache) {
+	delete(c.flags(ch.flag), ch.addr)
+}
+
+type storageWipe struct {
+	addr        common.Address
+	prevSlots   map[common.Hash]common.Hash
+	prevCleared bool
+}
+
+func (ch storageWipe) revert(c *StateCache) {
+	if ch.prevSlots != nil {
+		c.storage[ch.addr] = ch.prevSlots
+	} else {
+		delete(c.storage, ch.addr)
+	}
+	if ch.prevCleared {
+		c.cleared[ch.addr] = true
+	} else {
+		delete(c.cleared, ch.addr)
+	}
+}
+
+func (f *StateFork) setFlag(flag lifecycleFlag, addr common.Address) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	set := f.cache.flags(flag)
+	if set[addr] {
+		return
+	}
+	f.journal.append(lifecycleChange{flag: flag, addr: addr})
+	set[addr] = true
+}
+
+func (f *StateFork) hasFlag(flag lifecycleFlag, addr common.Address) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cache.flags(flag)[addr]
+}
+
+// wipeStorage drops every slot of addr, including ones never fetched
+func (f *StateFork) wipeStorage(addr common.Address) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.journal.append(storageWipe{
+		addr:        addr,
+		prevSlots:   f.cache.storage[addr],
+		prevCleared: f.cache.cleared[addr],
+	})
+	f.cache.storage[addr] = make(map[common.Hash]common.Hash)
+	f.cache.cleared[addr] = true
+}
+
+// resetAccount turns addr into an empty account with no storage
+func (f *StateFork) resetAccount(addr common.Address) {
+	f.SetBalance(addr, new(big.Int))
+	f.SetNonce(addr, 0)
+	f.SetCode(addr, []byte{})
+	f.wipeStorage(addr)
+}
+
+// finaliseTx deletes self-destructed accounts and, if deleteEmpty is set,
+// touched accounts that ended up empty, then starts a fresh lifecycle
+func (f *StateFork) finaliseTx(deleteEmpty bool, isEmpty func(common.Address) bool) {
+	f.mu.RLock()
+	var doomed []common.Address
+	for addr := range f.cache.destructed {
+		doomed = append(doomed, addr)
+	}
+	var touched []common.Address
+	if deleteEmpty {
+		for addr := range f.cache.touched {
+			if !f.cache.destructed[addr] {
+				touched = append(touched, addr)
+			}
+		}
+	}
+	f.mu.RUnlock()
+
+	for _, addr := range touched {
+		if isEmpty(addr) {
+			doomed = append(doomed, addr)
+		}
+	}
+	for _, addr := range doomed {
+		f.resetAccount(addr)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cache.created = make(map[common.Address]bool)
+	f.cache.destructed = make(map[common.Address]bool)
+	f.cache.touched = make(map[common.Address]bool)
+}
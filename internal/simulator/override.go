@@ -0,0 +1,110 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// CallArgs is the transaction object of eth_call / eth_estimateGas. Input
+// wins over Data when both are set, as in geth.
+type CallArgs struct {
+	From                 *common.Address  `json:"from"`
+	To                   *common.Address  `json:"to"`
+	Gas                  *hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big     `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big     `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big     `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big     `json:"value"`
+	Data                 *hexutil.Bytes   `json:"data"`
+	Input                *hexutil.Bytes   `json:"input"`
+	AccessList           types.AccessList `json:"accessList"`
+}
+
+// ToCallMsg converts the args for ExecuteCall / EstimateGas
+func (a *CallArgs) ToCallMsg() ethereum.CallMsg {
+	msg := ethereum.CallMsg{
+		To:         a.To,
+		GasPrice:   (*big.Int)(a.GasPrice),
+		GasFeeCap:  (*big.Int)(a.MaxFeePerGas),
+		GasTipCap:  (*big.Int)(a.MaxPriorityFeePerGas),
+		Value:      (*big.Int)(a.Value),
+		AccessList: a.AccessList,
+	}
+	if a.From != nil {
+		msg.From = *a.From
+	}
+	if a.Gas != nil {
+		msg.Gas = uint64(*a.Gas)
+	}
+	if a.Input != nil {
+		msg.Data = *a.Input
+	} else if a.Data != nil {
+		msg.Data = *a.Data
+	}
+	return msg
+}
+
+// OverrideAccount is one account of an eth_call state override set. State
+// replaces the whole storage, StateDiff only patches the given slots.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64             `json:"nonce,omitempty"`
+	Code      *hexutil.Bytes              `json:"code,omitempty"`
+	Balance   *hexutil.Big                `json:"balance,omitempty"`
+	State     map[common.Hash]common.Hash `json:"state,omitempty"`
+	StateDiff map[common.Hash]common.Hash `json:"stateDiff,omitempty"`
+}
+
+// StateOverride is the stateOverride argument of eth_call, keyed by address
+type StateOverride map[common.Address]OverrideAccount
+
+// LoadStateOverride reads a state override set from a JSON file
+func LoadStateOverride(path string) (StateOverride, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides: %w", err)
+	}
+	var o StateOverride
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("failed to decode overrides %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// ApplyOverrides writes the override set into the fork. The writes are
+// journaled like any other, so a snapshot taken before can undo them.
+func (f *StateFork) ApplyOverrides(o StateOverride) error {
+	for addr, acc := range o {
+		if acc.State != nil && acc.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+	}
+	for addr, acc := range o {
+		if acc.Balance != nil {
+			f.SetBalance(addr, (*big.Int)(acc.Balance))
+		}
+		if acc.Nonce != nil {
+			f.SetNonce(addr, uint64(*acc.Nonce))
+		}
+		if acc.Code != nil {
+			f.SetCode(addr, common.CopyBytes(*acc.Code))
+		}
+		if acc.State != nil {
+			// unfetched slots read as zero from now on
+			f.wipeStorage(addr)
+			for slot, val := range acc.State {
+				f.SetStorageAt(addr, slot, val)
+			}
+		}
+		for slot, val := range acc.StateDiff {
+			f.SetStorageAt(addr, slot, val)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package simulator
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	token := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	pool := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	bot := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	one, two, three := common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03")
+
+	_, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetStorage(testBlock, token, one, common.HexToHash("0xaa"))
+		n.SetStorage(testBlock, token, two, common.HexToHash("0xbb"))
+		n.SetStorage(testBlock, pool, one, common.HexToHash("0xcc"))
+		n.SetStorage(testBlock, pool, two, common.HexToHash("0xdd"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	err := os.WriteFile(path, []byte(`{
+		"0x3333333333333333333333333333333333333333": {"balance": "0xde0b6b3a7640000", "nonce": "0x5", "code": "0x6000"},
+		"0x1111111111111111111111111111111111111111": {"stateDiff": {"0x0000000000000000000000000000000000000000000000000000000000000002": "0x00000000000000000000000000000000000000000000000000000000000000ee"}},
+		"0x2222222222222222222222222222222222222222": {"state": {"0x0000000000000000000000000000000000000000000000000000000000000003": "0x00000000000000000000000000000000000000000000000000000000000000ff"}}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := LoadStateOverride(path)
+	if err != nil {
+		t.Fatalf("LoadStateOverride: %v", err)
+	}
+
+	snap := fork.Snapshot()
+	if err := fork.ApplyOverrides(overrides); err != nil {
+		t.Fatalf("ApplyOverrides: %v", err)
+	}
+
+	if bal, _ := fork.GetBalance(bot); bal.Cmp(big.NewInt(1e18)) != 0 {
+		t.Errorf("bot balance = %s, want 1e18", bal)
+	}
+	if n, _ := fork.GetNonce(bot); n != 5 {
+		t.Errorf("bot nonce = %d, want 5", n)
+	}
+	if code, _ := fork.GetCode(bot); common.Bytes2Hex(code) != "6000" {
+		t.Errorf("bot code = %x", code)
+	}
+
+	// stateDiff patches one slot, state replaces the whole storage
+	want := map[common.Address]map[common.Hash]common.Hash{
+		token: {one: common.HexToHash("0xaa"), two: common.HexToHash("0xee")},
+		pool:  {one: {}, two: {}, three: common.HexToHash("0xff")},
+	}
+	for addr, slots := range want {
+		for slot, val := range slots {
+			if got, _ := fork.GetStorageAt(addr, slot); got != val {
+				t.Errorf("%s slot %s = %s, want %s", addr.Hex(), slot.Hex(), got.Hex(), val.Hex())
+			}
+		}
+	}
+
+	// overrides are journaled
+	fork.RevertToSnapshot(snap)
+	if got, _ := fork.GetStorageAt(pool, two); got != common.HexToHash("0xdd") {
+		t.Errorf("pool slot 2 after revert = %s, want 0xdd", got.Hex())
+	}
+	if bal, _ := fork.GetBalance(bot); bal.Sign() != 0 {
+		t.Errorf("bot balance after revert = %s", bal)
+	}
+
+	bad := StateOverride{token: {State: map[common.Hash]common.Hash{}, StateDiff: map[common.Hash]common.Hash{}}}
+	if err := fork.ApplyOverrides(bad); err == nil {
+		t.Error("state and stateDiff together accepted")
+	}
+}
@@ -0,0 +1,193 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	errorStringSelector = []byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	panicSelector       = []byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+)
+
+// knownErrors are custom errors we run into on mainnet routers and tokens
+var knownErrors = []string{
+	// Uniswap Universal Router
+	"V2TooLittleReceived()",
+	"V2TooMuchRequested()",
+	"V2InvalidPath()",
+	"V3TooLittleReceived()",
+	"V3TooMuchRequested()",
+	"V3InvalidSwap()",
+	"V3InvalidAmountOut()",
+	"V3InvalidCaller()",
+	"TransactionDeadlinePassed()",
+	"ExecutionFailed(uint256,bytes)",
+	"InsufficientETH()",
+	"InsufficientToken()",
+	"InvalidCommandType(uint256)",
+	"LengthMismatch()",
+	"BalanceTooLow()",
+	"SliceOutOfBounds()",
+	"ContractLocked()",
+
+	// Permit2
+	"AllowanceExpired(uint256)",
+	"InsufficientAllowance(uint256)",
+	"SignatureExpired(uint256)",
+	"InvalidNonce()",
+	"InvalidSignature()",
+
+	// OpenZeppelin 5 ERC-20 (draft-IERC6093)
+	"ERC20InsufficientBalance(address,uint256,uint256)",
+	"ERC20InsufficientAllowance(address,uint256,uint256)",
+	"ERC20InvalidSender(address)",
+	"ERC20InvalidReceiver(address)",
+	"ERC20InvalidApprover(address)",
+	"ERC20InvalidSpender(address)",
+	"SafeERC20FailedOperation(address)",
+
+	// Solady / Solmate SafeTransferLib
+	"TransferFailed()",
+	"TransferFromFailed()",
+	"ApproveFailed()",
+	"ETHTransferFailed()",
+}
+
+// ErrorRegistry decodes revert data: Error(string), Panic(uint256) and any
+// custom error that was registered by ABI or signature
+type ErrorRegistry struct {
+	mu     sync.RWMutex
+	errors map[[4]byte]abi.Error
+}
+
+func NewErrorRegistry() *ErrorRegistry {
+	return &ErrorRegistry{errors: make(map[[4]byte]abi.Error)}
+}
+
+// DefaultErrors is used by the executor; it knows the common router and
+// token errors and can be extended at startup
+var DefaultErrors = newDefaultErrors()
+
+func newDefaultErrors() *ErrorRegistry {
+	r := NewErrorRegistry()
+	for _, sig := range knownErrors {
+		if err := r.RegisterSignature(sig); err != nil {
+			panic(fmt.Sprintf("bad built-in error signature %s: %v", sig, err))
+		}
+	}
+	return r
+}
+
+// RegisterABI adds every error declared in a contract's JSON ABI
+func (r *ErrorRegistry) RegisterABI(jsonABI string) error {
+	parsed, err := abi.JSON(strings.NewReader(jsonABI))
+	if err != nil {
+		return fmt.Errorf("failed to parse ABI: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range parsed.Errors {
+		r.errors[selectorOf(e.ID)] = e
+	}
+	return nil
+}
+
+// RegisterSignature adds an error from its signature, e.g.
+// "InsufficientLiquidity(uint256,uint256)". Tuple arguments are not supported.
+func (r *ErrorRegistry) RegisterSignature(sig string) error {
+	open, close := strings.IndexByte(sig, '('), strings.LastIndexByte(sig, ')')
+	if open <= 0 || close != len(sig)-1 {
+		return fmt.Errorf("invalid error signature %q", sig)
+	}
+	name := sig[:open]
+
+	var inputs abi.Arguments
+	if params := sig[open+1 : close]; params != "" {
+		for i, t := range strings.Split(params, ",") {
+			typ, err := abi.NewType(strings.TrimSpace(t), "", nil)
+			if err != nil {
+				return fmt.Errorf("invalid type in %q: %w", sig, err)
+			}
+			inputs = append(inputs, abi.Argument{Name: fmt.Sprintf("arg%d", i), Type: typ})
+		}
+	}
+
+	e := abi.NewError(name, inputs)
+	r.mu.Lock()
+	r.errors[selectorOf(e.ID)] = e
+	r.mu.Unlock()
+	return nil
+}
+
+// Decode turns revert data into a readable reason. Unknown selectors come
+// back as the raw hex so they can be looked up by hand.
+func (r *ErrorRegistry) Decode(data []byte) string {
+	if len(data) == 0 {
+		return "no revert data"
+	}
+	if len(data) < 4 {
+		return fmt.Sprintf("invalid revert data %s", hexutil.Encode(data))
+	}
+
+	switch {
+	case bytes.Equal(data[:4], errorStringSelector):
+		if reason, err := abi.UnpackRevert(data); err == nil {
+			return reason
+		}
+	case bytes.Equal(data[:4], panicSelector):
+		if reason, err := abi.UnpackRevert(data); err == nil {
+			return fmt.Sprintf("panic: %s (%#x)", reason, new(big.Int).SetBytes(data[4:]))
+		}
+	}
+
+	r.mu.RLock()
+	e, ok := r.errors[[4]byte(data[:4])]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Sprintf("unknown error %s", hexutil.Encode(data))
+	}
+	args, err := e.Inputs.Unpack(data[4:])
+	if err != nil {
+		return fmt.Sprintf("%s (undecodable args %s)", e.Name, hexutil.Encode(data[4:]))
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = r.formatArg(arg)
+	}
+	return fmt.Sprintf("%s(%s)", e.Name, strings.Join(parts, ", "))
+}
+
+// formatArg prints an error argument; bytes that hold nested revert data
+// (Universal Router's ExecutionFailed, multicalls) are decoded too
+func (r *ErrorRegistry) formatArg(arg interface{}) string {
+	switch v := arg.(type) {
+	case []byte:
+		if len(v) >= 4 && (len(v)-4)%32 == 0 {
+			return r.Decode(v)
+		}
+		return hexutil.Encode(v)
+	case common.Address:
+		return v.Hex()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// DecodeRevert decodes revert data with DefaultErrors
+func DecodeRevert(data []byte) string {
+	return DefaultErrors.Decode(data)
+}
+
+func selectorOf(id common.Hash) [4]byte {
+	var sel [4]byte
+	copy(sel[:], id[:4])
+	return sel
+}
@@ -0,0 +1,101 @@
+package simulator
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+// encodeError builds revert data for the error with the given signature
+func encodeError(t *testing.T, sig string, args ...interface{}) []byte {
+	t.Helper()
+	r := NewErrorRegistry()
+	if err := r.RegisterSignature(sig); err != nil {
+		t.Fatalf("RegisterSignature: %v", err)
+	}
+	e := r.errors[selectorOf(crypto.Keccak256Hash([]byte(sig)))]
+	packed, err := e.Inputs.Pack(args...)
+	if err != nil {
+		t.Fatalf("pack %s: %v", sig, err)
+	}
+	return append(crypto.Keccak256([]byte(sig))[:4], packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	owner := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	insufficient := encodeError(t, "ERC20InsufficientBalance(address,uint256,uint256)", owner, big.NewInt(5), big.NewInt(10))
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"error string", encodeError(t, "Error(string)", "UniswapV2Router: INSUFFICIENT_OUTPUT_AMOUNT"), "UniswapV2Router: INSUFFICIENT_OUTPUT_AMOUNT"},
+		{"panic", encodeError(t, "Panic(uint256)", big.NewInt(0x11)), "panic: arithmetic underflow or overflow (0x11)"},
+		{"custom", insufficient, "ERC20InsufficientBalance(" + owner.Hex() + ", 5, 10)"},
+		{"nested", encodeError(t, "ExecutionFailed(uint256,bytes)", big.NewInt(1), insufficient), "ExecutionFailed(1, ERC20InsufficientBalance(" + owner.Hex() + ", 5, 10))"},
+		{"unknown", []byte{0xde, 0xad, 0xbe, 0xef}, "unknown error 0xdeadbeef"},
+		{"empty", nil, "no revert data"},
+	}
+	for _, tt := range tests {
+		if got := DecodeRevert(tt.data); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterABI(t *testing.T) {
+	const poolABI = `[{"type":"error","name":"InsufficientLiquidity","inputs":[{"name":"have","type":"uint256"},{"name":"want","type":"uint256"}]}]`
+	r := NewErrorRegistry()
+	if err := r.RegisterABI(poolABI); err != nil {
+		t.Fatalf("RegisterABI: %v", err)
+	}
+	parsed, _ := abi.JSON(strings.NewReader(poolABI))
+	e := parsed.Errors["InsufficientLiquidity"]
+	packed, _ := e.Inputs.Pack(big.NewInt(1), big.NewInt(2))
+	data := append(e.ID.Bytes()[:4], packed...)
+	if got := r.Decode(data); got != "InsufficientLiquidity(1, 2)" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRevertReasonInResult(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	// copy the revert data into memory and revert with it
+	data := encodeError(t, "Error(string)", "boom")
+	code := common.FromHex("7f" + common.Bytes2Hex(data[:32]) + "6000" + "52" + // mstore(0, word0)
+		"7f" + common.Bytes2Hex(data[32:64]) + "6020" + "52" + // mstore(32, word1)
+		"7f" + common.Bytes2Hex(data[64:96]) + "6040" + "52" + // mstore(64, word2)
+		"60" + "64" + "6000" + "fd") // revert(0, 100)
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, contract, code)
+		n.AddBlock(testBlock, nil, nil)
+	})
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.LegacyTx{
+		GasPrice: big.NewInt(20e9),
+		Gas:      100000,
+		To:       &contract,
+	})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	result, err := NewExecutor(fork).ExecuteTransaction(tx, types.NewBlockWithHeader(node.Header(testBlock+1)))
+	if err != nil {
+		t.Fatalf("ExecuteTransaction: %v", err)
+	}
+	if result.Success || result.RevertReason != "execution reverted: boom" {
+		t.Errorf("success=%v reason=%q", result.Success, result.RevertReason)
+	}
+}
@@ -22,6 +22,29 @@ type ForkedStateDB struct {
 	accessList     map[common.Address]map[common.Hash]bool
 	accessListAddr map[common.Address]bool
 	originalStorage map[common.Address]map[common.Hash]common.Hash
+
+	// per-tx state that call-frame reverts must undo too
+	marks         map[int]stateDBMark
+	accessListLog []accessListAdd
+
+	// records pre-state for the state diff, may be nil
+	tracker *StateTracker
+
+	// tracer state hooks (balance, nonce, code, storage, logs), may be nil
+	hooks *tracing.Hooks
+}
+
+// stateDBMark is where logs, refund and access list stood at a snapshot
+type stateDBMark struct {
+	logs       int
+	refund     uint64
+	accessList int
+}
+
+// accessListAdd is one warmed address (slot == nil) or slot
+type accessListAdd struct {
+	addr common.Address
+	slot *common.Hash
 }
 
 func NewForkedStateDB(fork *StateFork) *ForkedStateDB {
@@ -32,18 +55,33 @@ func NewForkedStateDB(fork *StateFork) *ForkedStateDB {
 		accessList:     make(map[common.Address]map[common.Hash]bool),
 		accessListAddr: make(map[common.Address]bool),
 		originalStorage: make(map[common.Address]map[common.Hash]common.Hash),
+		marks:           make(map[int]stateDBMark),
 	}
 }
 
-// CreateAccount creates a new account
+// CreateAccount creates a new empty account, dropping any storage left over
+// from earlier state
 func (s *ForkedStateDB) CreateAccount(addr common.Address) {
-	s.fork.SetBalance(addr, big.NewInt(0))
-	s.fork.SetNonce(addr, 0)
+	s.recordAccount(addr)
+	s.fork.resetAccount(addr)
+	s.fork.setFlag(flagTouched, addr)
 }
 
-// CreateContract is like CreateAccount but for contract creation
+// CreateContract marks addr as created in this tx (for EIP-6780). It may be
+// preceded by CreateAccount, or the account may already hold funds.
 func (s *ForkedStateDB) CreateContract(addr common.Address) {
-	s.CreateAccount(addr)
+	if s.tracker != nil {
+		s.tracker.recordCreated(addr)
+	}
+	s.fork.setFlag(flagCreated, addr)
+	s.fork.setFlag(flagTouched, addr)
+}
+
+// recordAccount hands addr to the state diff tracker before a write
+func (s *ForkedStateDB) recordAccount(addr common.Address) {
+	if s.tracker != nil {
+		s.tracker.recordAccount(addr)
+	}
 }
 
 // Balance operations with uint256
@@ -60,16 +98,26 @@ func (s *ForkedStateDB) GetBalance(addr common.Address) *uint256.Int {
 }
 
 func (s *ForkedStateDB) AddBalance(addr common.Address, amount *uint256.Int, reason tracing.BalanceChangeReason) uint256.Int {
+	s.recordAccount(addr)
 	bal := s.GetBalance(addr)
 	newBal := new(uint256.Int).Add(bal, amount)
 	s.fork.SetBalance(addr, newBal.ToBig())
+	if s.hooks != nil && s.hooks.OnBalanceChange != nil && !amount.IsZero() {
+		s.hooks.OnBalanceChange(addr, bal.ToBig(), newBal.ToBig(), reason)
+	}
+	s.fork.setFlag(flagTouched, addr)
 	return *bal // Return value, not pointer
 }
 
 func (s *ForkedStateDB) SubBalance(addr common.Address, amount *uint256.Int, reason tracing.BalanceChangeReason) uint256.Int {
+	s.recordAccount(addr)
 	bal := s.GetBalance(addr)
 	newBal := new(uint256.Int).Sub(bal, amount)
 	s.fork.SetBalance(addr, newBal.ToBig())
+	if s.hooks != nil && s.hooks.OnBalanceChange != nil && !amount.IsZero() {
+		s.hooks.OnBalanceChange(addr, bal.ToBig(), newBal.ToBig(), reason)
+	}
+	s.fork.setFlag(flagTouched, addr)
 	return *bal // Return value, not pointer
 }
 
@@ -83,7 +131,17 @@ func (s *ForkedStateDB) GetNonce(addr common.Address) uint64 {
 }
 
 func (s *ForkedStateDB) SetNonce(addr common.Address, nonce uint64, reason tracing.NonceChangeReason) {
+	s.recordAccount(addr)
+	if s.hooks != nil {
+		prev := s.GetNonce(addr)
+		if s.hooks.OnNonceChangeV2 != nil {
+			s.hooks.OnNonceChangeV2(addr, prev, nonce, reason)
+		} else if s.hooks.OnNonceChange != nil {
+			s.hooks.OnNonceChange(addr, prev, nonce)
+		}
+	}
 	s.fork.SetNonce(addr, nonce)
+	s.fork.setFlag(flagTouched, addr)
 }
 
 // Code operations
@@ -107,14 +165,22 @@ func (s *ForkedStateDB) GetCodeHash(addr common.Address) common.Hash {
 		}
 		return common.Hash{}
 	}
-	return common.BytesToHash(code)
+	return crypto.Keccak256Hash(code)
 }
 
 func (s *ForkedStateDB) SetCode(addr common.Address, code []byte, reason tracing.CodeChangeReason) []byte {
+	s.recordAccount(addr)
 	oldCode := s.GetCode(addr)
-	s.fork.mu.Lock()
-	defer s.fork.mu.Unlock()
-	s.fork.cache.code[addr] = code
+	s.fork.SetCode(addr, code)
+	if s.hooks != nil {
+		prevHash, codeHash := crypto.Keccak256Hash(oldCode), crypto.Keccak256Hash(code)
+		if s.hooks.OnCodeChangeV2 != nil {
+			s.hooks.OnCodeChangeV2(addr, prevHash, oldCode, codeHash, code, reason)
+		} else if s.hooks.OnCodeChange != nil {
+			s.hooks.OnCodeChange(addr, prevHash, oldCode, codeHash, code)
+		}
+	}
+	s.fork.setFlag(flagTouched, addr)
 	return oldCode
 }
 
@@ -128,8 +194,15 @@ func (s *ForkedStateDB) GetState(addr common.Address, hash common.Hash) common.H
 }
 
 func (s *ForkedStateDB) SetState(addr common.Address, key, value common.Hash) common.Hash {
+	if s.tracker != nil {
+		s.tracker.recordSlot(addr, key)
+	}
 	oldVal := s.GetState(addr, key)
 	s.fork.SetStorageAt(addr, key, value)
+	if s.hooks != nil && s.hooks.OnStorageChange != nil && oldVal != value {
+		s.hooks.OnStorageChange(addr, key, oldVal, value)
+	}
+	s.fork.setFlag(flagTouched, addr)
 	return oldVal
 }
 
@@ -154,13 +227,13 @@ func (s *ForkedStateDB) GetStorageRoot(addr common.Address) common.Hash {
 	return common.Hash{}
 }
 
-// Transient storage (EIP-1153)
+// Transient storage (EIP-1153), kept on the fork so it follows snapshots
 func (s *ForkedStateDB) GetTransientState(addr common.Address, key common.Hash) common.Hash {
-	return common.Hash{}
+	return s.fork.GetTransientState(addr, key)
 }
 
 func (s *ForkedStateDB) SetTransientState(addr common.Address, key, value common.Hash) {
-	// No-op for now
+	s.fork.SetTransientState(addr, key, value)
 }
 
 // Account existence
@@ -180,16 +253,44 @@ func (s *ForkedStateDB) Empty(addr common.Address) bool {
 
 // Snapshot operations
 func (s *ForkedStateDB) Snapshot() int {
-	return s.fork.Snapshot()
+	id := s.fork.Snapshot()
+	s.marks[id] = stateDBMark{logs: len(s.logs), refund: s.refund, accessList: len(s.accessListLog)}
+	return id
 }
 
+// RevertToSnapshot undoes state writes on the fork and drops the logs,
+// refund and access list warming of the reverted frames, like geth's journal
 func (s *ForkedStateDB) RevertToSnapshot(id int) {
 	s.fork.RevertToSnapshot(id)
+
+	m, ok := s.marks[id]
+	if !ok {
+		return
+	}
+	s.logs = s.logs[:m.logs]
+	s.refund = m.refund
+	for i := len(s.accessListLog) - 1; i >= m.accessList; i-- {
+		add := s.accessListLog[i]
+		if add.slot != nil {
+			delete(s.accessList[add.addr], *add.slot)
+		} else {
+			delete(s.accessListAddr, add.addr)
+		}
+	}
+	s.accessListLog = s.accessListLog[:m.accessList]
+	for markID := range s.marks {
+		if markID >= id {
+			delete(s.marks, markID)
+		}
+	}
 }
 
 // Logs
 func (s *ForkedStateDB) AddLog(log *types.Log) {
 	s.logs = append(s.logs, log)
+	if s.hooks != nil && s.hooks.OnLog != nil {
+		s.hooks.OnLog(log)
+	}
 }
 
 func (s *ForkedStateDB) Logs() []*types.Log {
@@ -216,30 +317,51 @@ func (s *ForkedStateDB) GetRefund() uint64 {
 // Preimages
 func (s *ForkedStateDB) AddPreimage(hash common.Hash, preimage []byte) {}
 
-// Self-destruct operations
+// Self-destruct operations. The account is only removed in Finalise, until
+// then it keeps its code and storage.
 func (s *ForkedStateDB) SelfDestruct(addr common.Address) uint256.Int {
+	s.recordAccount(addr)
 	bal := s.GetBalance(addr)
 	s.fork.SetBalance(addr, big.NewInt(0))
+	if s.hooks != nil && s.hooks.OnBalanceChange != nil && !bal.IsZero() {
+		s.hooks.OnBalanceChange(addr, bal.ToBig(), new(big.Int), tracing.BalanceDecreaseSelfdestruct)
+	}
+	s.fork.setFlag(flagDestructed, addr)
+	s.fork.setFlag(flagTouched, addr)
 	return *bal
 }
 
 func (s *ForkedStateDB) HasSelfDestructed(addr common.Address) bool {
-	return false
+	return s.fork.hasFlag(flagDestructed, addr)
 }
 
+// SelfDestruct6780 only destructs contracts created in the same tx (Cancun)
 func (s *ForkedStateDB) SelfDestruct6780(addr common.Address) (uint256.Int, bool) {
-	return s.SelfDestruct(addr), true
+	if s.fork.hasFlag(flagCreated, addr) {
+		return s.SelfDestruct(addr), true
+	}
+	return *s.GetBalance(addr), false
 }
 
 // Access list (EIP-2929)
-func (s *ForkedStateDB) AddAddressToAccessList(addr common.Address) {s.accessListAddr[addr] = true}
+func (s *ForkedStateDB) AddAddressToAccessList(addr common.Address) {
+	if s.accessListAddr[addr] {
+		return
+	}
+	s.accessListAddr[addr] = true
+	s.accessListLog = append(s.accessListLog, accessListAdd{addr: addr})
+}
 
 func (s *ForkedStateDB) AddSlotToAccessList(addr common.Address, slot common.Hash) {
-	s.accessListAddr[addr] = true
-    if s.accessList[addr] == nil {
-        s.accessList[addr] = make(map[common.Hash]bool)
-    }
-    s.accessList[addr][slot] = true
+	s.AddAddressToAccessList(addr)
+	if s.accessList[addr] == nil {
+		s.accessList[addr] = make(map[common.Hash]bool)
+	}
+	if s.accessList[addr][slot] {
+		return
+	}
+	s.accessList[addr][slot] = true
+	s.accessListLog = append(s.accessListLog, accessListAdd{addr: addr, slot: &slot})
 }
 
 func (s *ForkedStateDB) AddressInAccessList(addr common.Address) bool {
@@ -259,6 +381,9 @@ func (s *ForkedStateDB) SlotInAccessList(addr common.Address, slot common.Hash)
 
 // Prepare for transaction execution
 func (s *ForkedStateDB) Prepare(rules params.Rules, sender, coinbase common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList) {
+	// every tx starts with empty transient storage
+	s.fork.clearTransientStorage()
+
 	s.AddAddressToAccessList(sender)
 	if dest != nil {
 		s.AddAddressToAccessList(*dest)
@@ -290,7 +415,8 @@ func (s *ForkedStateDB) AccessEvents() *state.AccessEvents {
 	return nil
 }
 
-// Finalise completes the state transition
+// Finalise ends the tx: self-destructed accounts are deleted, and with
+// deleteEmptyObjects (EIP-161) so are touched accounts left empty
 func (s *ForkedStateDB) Finalise(deleteEmptyObjects bool) {
-	// No-op for our simple implementation
+	s.fork.finaliseTx(deleteEmptyObjects, s.Empty)
 }
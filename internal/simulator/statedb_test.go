@@ -0,0 +1,159 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+// testnode timestamps put this block after Dencun (TSTORE/TLOAD enabled)
+const cancunBlock = 19_500_000
+
+func TestTransientStorageFollowsSnapshots(t *testing.T) {
+	s := NewForkedStateDB(newCacheOnlyFork())
+	addr := common.HexToAddress("0x01")
+	key := common.HexToHash("0x01")
+
+	s.SetTransientState(addr, key, common.HexToHash("0x0a"))
+	snap := s.Snapshot()
+	s.SetTransientState(addr, key, common.HexToHash("0x0b"))
+	s.SetTransientState(addr, common.HexToHash("0x02"), common.HexToHash("0x0c"))
+
+	s.RevertToSnapshot(snap)
+	if got := s.GetTransientState(addr, key); got != common.HexToHash("0x0a") {
+		t.Errorf("after revert = %s, want 0x0a", got.Hex())
+	}
+	if got := s.GetTransientState(addr, common.HexToHash("0x02")); got != (common.Hash{}) {
+		t.Errorf("slot written after snapshot = %s, want zero", got.Hex())
+	}
+
+	s.Prepare(params.Rules{}, common.Address{}, common.Address{}, nil, nil, nil)
+	if got := s.GetTransientState(addr, key); got != (common.Hash{}) {
+		t.Errorf("Prepare did not clear transient storage, got %s", got.Hex())
+	}
+}
+
+func TestTransientStorageClearedBetweenTxs(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.HexToAddress("0x6666666666666666666666666666666666666666")
+
+	// sstore(0, tload(0)); tstore(0, 1); sstore(1, tload(0))
+	code := common.FromHex("60005c600055" + "600160005d" + "60005c600155" + "00")
+
+	node, fork := newTestFork(t, cancunBlock, func(n *testnode.Node) {
+		n.SetBalance(cancunBlock, sender, big.NewInt(1e18))
+		n.SetCode(cancunBlock, contract, code)
+		n.AddBlock(cancunBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(cancunBlock + 1))
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	sim := NewBundleSimulator(fork)
+	var txs []*types.Transaction
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: big.NewInt(20e9),
+			Gas:      100000,
+			To:       &contract,
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	result, err := sim.ExecuteBundle(txs, block)
+	if err != nil || !result.Success {
+		t.Fatalf("bundle failed: %v %+v", err, result)
+	}
+
+	// TSTORE is visible later in the same tx...
+	if v, _ := fork.GetStorageAt(contract, common.HexToHash("0x01")); v != common.HexToHash("0x01") {
+		t.Errorf("tload after tstore = %s, want 1", v.Hex())
+	}
+	// ...but the second tx starts from empty transient storage
+	if v, _ := fork.GetStorageAt(contract, common.Hash{}); v != (common.Hash{}) {
+		t.Errorf("second tx saw transient value %s from the first", v.Hex())
+	}
+}
+
+func TestSelfDestructLifecycle(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	beneficiary := common.HexToAddress("0x7777777777777777777777777777777777777777")
+	existing := common.HexToAddress("0x8888888888888888888888888888888888888888")
+	factory := common.HexToAddress("0x9999999999999999999999999999999999999999")
+	slot1 := common.HexToHash("0x01")
+
+	selfDestruct := "73" + beneficiary.Hex()[2:] + "ff"
+	// child init code: sstore(1, 0x42); selfdestruct(beneficiary)
+	initCode := "6042600155" + selfDestruct
+	// factory: mstore(0, initCode); sstore(0, create(0, 5, 27))
+	factoryCode := common.FromHex("7a" + initCode + "600052" + "601b60056000f0" + "600055" + "00")
+	child := crypto.CreateAddress(factory, 1)
+
+	node, fork := newTestFork(t, cancunBlock, func(n *testnode.Node) {
+		n.SetBalance(cancunBlock, sender, big.NewInt(1e18))
+		n.SetBalance(cancunBlock, existing, big.NewInt(5))
+		n.SetCode(cancunBlock, existing, common.FromHex(selfDestruct))
+		n.SetStorage(cancunBlock, existing, slot1, common.HexToHash("0x42"))
+		n.SetNonce(cancunBlock, factory, 1)
+		n.SetCode(cancunBlock, factory, factoryCode)
+		n.AddBlock(cancunBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(cancunBlock + 1))
+	executor := NewExecutor(fork)
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+
+	send := func(nonce uint64, to common.Address) {
+		t.Helper()
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: big.NewInt(20e9),
+			Gas:      200000,
+			To:       &to,
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		result, err := executor.ExecuteTransaction(tx, block)
+		if err != nil || !result.Success {
+			t.Fatalf("tx to %s failed: %v %+v", to.Hex(), err, result)
+		}
+	}
+
+	// EIP-6780: a pre-existing contract only loses its balance
+	send(0, existing)
+	if bal, _ := fork.GetBalance(beneficiary); bal.Int64() != 5 {
+		t.Errorf("beneficiary balance = %s, want 5", bal)
+	}
+	if code, _ := fork.GetCode(existing); len(code) == 0 {
+		t.Errorf("pre-existing contract lost its code")
+	}
+	if v, _ := fork.GetStorageAt(existing, slot1); v != common.HexToHash("0x42") {
+		t.Errorf("pre-existing contract lost its storage")
+	}
+
+	// created and destroyed in the same tx: the account is gone for good
+	send(1, factory)
+	if v, _ := fork.GetStorageAt(factory, common.Hash{}); common.BytesToAddress(v.Bytes()) != child {
+		t.Fatalf("factory recorded child %s, want %s", v.Hex(), child.Hex())
+	}
+	s := NewForkedStateDB(fork)
+	if s.Exist(child) || len(s.GetCode(child)) != 0 {
+		t.Errorf("child still exists after self-destructing in its creation tx")
+	}
+	if v := s.GetState(child, slot1); v != (common.Hash{}) {
+		t.Errorf("child storage survived deletion: %s", v.Hex())
+	}
+	if s.GetCodeHash(existing) != crypto.Keccak256Hash(common.FromHex(selfDestruct)) {
+		t.Errorf("GetCodeHash is not the keccak of the code")
+	}
+}
@@ -0,0 +1,160 @@
+package simulator
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// recordAccount captures addr's balance, nonce and code the first time it is
+// written. Later writes keep the first values, so reverted frames simply show
+// up as unchanged.
+func (t *StateTracker) recordAccount(addr common.Address) *AccountState {
+	if acc, ok := t.pre[addr]; ok {
+		return acc
+	}
+	acc := t.current(addr)
+	acc.Storage = make(map[common.Hash]common.Hash)
+	t.pre[addr] = acc
+	return acc
+}
+
+// recordSlot captures a storage slot before its first write
+func (t *StateTracker) recordSlot(addr common.Address, slot common.Hash) {
+	acc := t.recordAccount(addr)
+	if _, ok := acc.Storage[slot]; ok {
+		return
+	}
+	val, _ := t.fork.GetStorageAt(addr, slot)
+	acc.Storage[slot] = val
+}
+
+// recordCreated marks addr as a contract created during execution
+func (t *StateTracker) recordCreated(addr common.Address) {
+	t.recordAccount(addr)
+	t.created[addr] = true
+}
+
+// current reads addr's balance, nonce and code from the fork
+func (t *StateTracker) current(addr common.Address) *AccountState {
+	bal, err := t.fork.GetBalance(addr)
+	if err != nil {
+		bal = new(big.Int)
+	}
+	nonce, _ := t.fork.GetNonce(addr)
+	code, _ := t.fork.GetCode(addr)
+
+	acc := &AccountState{Balance: (*hexutil.Big)(bal), Nonce: nonce}
+	if len(code) > 0 {
+		hash := crypto.Keccak256Hash(code)
+		acc.Code = code
+		acc.CodeHash = &hash
+	}
+	return acc
+}
+
+// merge adds the pre-state recorded by a later execution on the same fork.
+// Anything t already tracks was recorded earlier and keeps its value.
+func (t *StateTracker) merge(other *StateTracker) {
+	for addr, acc := range other.pre {
+		mine, ok := t.pre[addr]
+		if !ok {
+			mine = &AccountState{Balance: acc.Balance, Code: acc.Code, CodeHash: acc.CodeHash, Nonce: acc.Nonce, Storage: make(map[common.Hash]common.Hash)}
+			t.pre[addr] = mine
+		}
+		for slot, val := range acc.Storage {
+			if _, ok := mine.Storage[slot]; !ok {
+				mine.Storage[slot] = val
+			}
+		}
+	}
+	for addr := range other.created {
+		t.created[addr] = true
+	}
+}
+
+// Changes diffs the recorded pre-state against the fork's current state the
+// way prestateTracer's diffMode does: untouched accounts and unchanged slots
+// are dropped, zeroed slots only appear in Pre. Nil for a nil tracker.
+func (t *StateTracker) Changes() *StateChanges {
+	if t == nil {
+		return nil
+	}
+	out := &StateChanges{
+		Pre:  make(map[common.Address]*AccountState),
+		Post: make(map[common.Address]*AccountState),
+	}
+	for addr, pre := range t.pre {
+		preExists := !isEmptyAccount(pre)
+
+		pre = &AccountState{Balance: pre.Balance, Code: pre.Code, CodeHash: pre.CodeHash, Nonce: pre.Nonce, Storage: copySlots(pre.Storage)}
+		cur := t.current(addr)
+		if isEmptyAccount(cur) {
+			// deleted (self-destruct or EIP-161) or never came to exist
+			if preExists {
+				out.Pre[addr] = pre
+			}
+			continue
+		}
+
+		post := &AccountState{}
+		modified := false
+		if pre.Balance.ToInt().Cmp(cur.Balance.ToInt()) != 0 {
+			post.Balance = cur.Balance
+			modified = true
+		}
+		if pre.Nonce != cur.Nonce {
+			post.Nonce = cur.Nonce
+			modified = true
+		}
+		if !equalCodeHash(pre.CodeHash, cur.CodeHash) {
+			post.Code = cur.Code
+			post.CodeHash = cur.CodeHash
+			modified = true
+		}
+		for slot, val := range pre.Storage {
+			newVal, _ := t.fork.GetStorageAt(addr, slot)
+			if newVal == val {
+				delete(pre.Storage, slot)
+				continue
+			}
+			modified = true
+			if newVal != (common.Hash{}) {
+				if post.Storage == nil {
+					post.Storage = make(map[common.Hash]common.Hash)
+				}
+				post.Storage[slot] = newVal
+			}
+		}
+		if !modified {
+			continue
+		}
+		out.Post[addr] = post
+		// contracts created from nothing have no meaningful pre-state
+		if !(t.created[addr] && !preExists) {
+			out.Pre[addr] = pre
+		}
+	}
+	return out
+}
+
+func isEmptyAccount(acc *AccountState) bool {
+	return acc.Balance.ToInt().Sign() == 0 && acc.Nonce == 0 && len(acc.Code) == 0
+}
+
+func equalCodeHash(a, b *common.Hash) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func copySlots(slots map[common.Hash]common.Hash) map[common.Hash]common.Hash {
+	out := make(map[common.Hash]common.Hash, len(slots))
+	for k, v := range slots {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package simulator
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestStateChanges(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := common.HexToAddress("0x7777777777777777777777777777777777777777")
+	slot0, slot1 := common.Hash{}, common.BigToHash(big.NewInt(1))
+
+	// sstore(0, 1); sstore(1, 0)
+	code := common.FromHex("6001600055" + "6000600155" + "00")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, contract, code)
+		n.SetStorage(testBlock, contract, slot0, common.HexToHash("0xbeef"))
+		n.SetStorage(testBlock, contract, slot1, common.HexToHash("0x05"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	var txs []*types.Transaction
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: big.NewInt(20e9),
+			Gas:      100000,
+			To:       &contract,
+		})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		txs = append(txs, tx)
+	}
+
+	// diffs are only recorded when asked for
+	snap := fork.Snapshot()
+	if r, err := NewBundleSimulator(fork).ExecuteBundle(txs, block); err != nil || r.StateChanges != nil || r.Transactions[0].StateChanges != nil {
+		t.Errorf("untracked ExecuteBundle: %v, diff %+v", err, r)
+	}
+	fork.RevertToSnapshot(snap)
+
+	sim := NewBundleSimulator(fork)
+	sim.SetTrackChanges(true)
+	result, err := sim.ExecuteBundle(txs, block)
+	if err != nil || !result.Success {
+		t.Fatalf("ExecuteBundle: %v %+v", err, result)
+	}
+
+	// first tx: slot 0 changes, slot 1 is zeroed so it only shows up in pre
+	first := result.Transactions[0].StateChanges
+	pre, post := first.Pre[contract], first.Post[contract]
+	if pre == nil || post == nil {
+		t.Fatalf("contract missing from diff: %+v", first)
+	}
+	if pre.Storage[slot0] != common.HexToHash("0xbeef") || pre.Storage[slot1] != common.HexToHash("0x05") {
+		t.Errorf("pre storage = %v", pre.Storage)
+	}
+	if len(post.Storage) != 1 || post.Storage[slot0] != common.HexToHash("0x01") {
+		t.Errorf("post storage = %v, want only slot 0 = 1", post.Storage)
+	}
+	if post.Balance != nil || post.Code != nil {
+		t.Errorf("unchanged contract fields in post: %+v", post)
+	}
+	if first.Post[sender].Nonce != 1 || first.Pre[sender].Balance.ToInt().Cmp(big.NewInt(1e18)) != 0 {
+		t.Errorf("sender diff pre=%+v post=%+v", first.Pre[sender], first.Post[sender])
+	}
+	if _, ok := first.Post[testnode.DefaultCoinbase]; !ok {
+		t.Error("coinbase tip missing from diff")
+	}
+
+	// second tx rewrites the same values, so the contract is unchanged
+	if _, ok := result.Transactions[1].StateChanges.Post[contract]; ok {
+		t.Error("second tx reported a contract change")
+	}
+
+	// the bundle diff spans both txs
+	bundle := result.StateChanges
+	if bundle.Pre[sender].Nonce != 0 || bundle.Post[sender].Nonce != 2 {
+		t.Errorf("bundle sender nonce %d -> %d, want 0 -> 2", bundle.Pre[sender].Nonce, bundle.Post[sender].Nonce)
+	}
+	if bundle.Pre[contract].Storage[slot0] != common.HexToHash("0xbeef") {
+		t.Errorf("bundle pre slot 0 = %s, want 0xbeef", bundle.Pre[contract].Storage[slot0].Hex())
+	}
+
+	// prestateTracer diffMode JSON shape
+	raw, err := json.Marshal(first)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded map[string]map[string]map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	// addresses are lowercase hex keys, like geth's output
+	got := decoded["post"][strings.ToLower(contract.Hex())]
+	if got == nil || got["storage"] == nil || got["balance"] != nil {
+		t.Errorf("unexpected post JSON for contract: %s", raw)
+	}
+	if decoded["pre"][strings.ToLower(sender.Hex())] == nil {
+		t.Errorf("sender missing from pre JSON: %s", raw)
+	}
+}
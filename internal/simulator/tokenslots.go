@@ -0,0 +1,188 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/storage"
+)
+
+// maxTokenSlot bounds the mapping slots tried, tokens declare balances and
+// allowances well within their first few dozen slots
+const maxTokenSlot = 128
+
+const tokenCallGas = 1_000_000
+
+var (
+	balanceOfSelector = common.FromHex("70a08231") // balanceOf(address)
+	allowanceSelector = common.FromHex("dd62ed3e") // allowance(address,address)
+
+	// accounts nobody holds tokens for, so their entries start out empty
+	probeOwner   = common.HexToAddress("0x000000000000000000000000000000000000f00d")
+	probeSpender = common.HexToAddress("0x000000000000000000000000000000000000beef")
+
+	// written into candidate slots, below 2^255 because some tokens (USDC)
+	// keep flags in the top bit of a balance
+	probeValue = common.HexToHash("0x00000000000000000000000000000000000000000000000000000000c0ffee11")
+)
+
+var errNoTokenSlot = errors.New("no mapping slot matches")
+
+// layoutSlot is the slot of keys in a (nested) mapping declared at slot.
+// Solidity hashes key.slot, Vyper slot.key.
+func layoutSlot(slot uint64, vyper bool, keys ...common.Address) common.Hash {
+	h := common.BigToHash(new(big.Int).SetUint64(slot))
+	for _, key := range keys {
+		k := common.BytesToHash(key.Bytes())
+		if vyper {
+			h = crypto.Keccak256Hash(h.Bytes(), k.Bytes())
+		} else {
+			h = crypto.Keccak256Hash(k.Bytes(), h.Bytes())
+		}
+	}
+	return h
+}
+
+// DiscoverTokenSlots finds the balanceOf and allowance mappings of an ERC-20.
+// It runs each getter for unused accounts, records the slots the token reads
+// (through proxies too) and matches them against the mapping slots of the
+// first maxTokenSlot declarations in Solidity and Vyper layouts. A match is
+// confirmed by writing a value there and reading it back through the getter.
+// Results are cached in the CacheDB; all writes are reverted.
+func (f *StateFork) DiscoverTokenSlots(token common.Address) (*storage.TokenSlots, error) {
+	if slots, ok := f.db.GetTokenSlots(token); ok {
+		return slots, nil
+	}
+
+	code, err := f.GetCode(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("token %s has no code", token.Hex())
+	}
+
+	balanceCall := append(common.CopyBytes(balanceOfSelector), common.LeftPadBytes(probeOwner.Bytes(), 32)...)
+	allowanceCall := append(common.CopyBytes(allowanceSelector), common.LeftPadBytes(probeOwner.Bytes(), 32)...)
+	allowanceCall = append(allowanceCall, common.LeftPadBytes(probeSpender.Bytes(), 32)...)
+
+	slots := new(storage.TokenSlots)
+	if slots.BalanceSlot, slots.BalanceVyper, err = f.findMappingSlot(token, balanceCall, probeOwner); err != nil {
+		return nil, fmt.Errorf("token %s balanceOf: %w", token.Hex(), err)
+	}
+	if slots.AllowanceSlot, slots.AllowanceVyper, err = f.findMappingSlot(token, allowanceCall, probeOwner, probeSpender); err != nil {
+		return nil, fmt.Errorf("token %s allowance: %w", token.Hex(), err)
+	}
+
+	f.db.SetTokenSlots(token, slots)
+	return slots, nil
+}
+
+// findMappingSlot returns the mapping declaration the getter input reads keys from
+func (f *StateFork) findMappingSlot(token common.Address, input []byte, keys ...common.Address) (uint64, bool, error) {
+	reads, _, err := f.tokenCall(token, input)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := uint64(0); i < maxTokenSlot; i++ {
+		for _, vyper := range []bool{false, true} {
+			slot := layoutSlot(i, vyper, keys...)
+			if !reads[slot] {
+				continue
+			}
+			ok, err := f.checkTokenSlot(token, input, slot)
+			if err != nil {
+				return 0, false, err
+			}
+			if ok {
+				return i, vyper, nil
+			}
+		}
+	}
+	return 0, false, errNoTokenSlot
+}
+
+// checkTokenSlot tells if the getter returns whatever is stored at slot
+func (f *StateFork) checkTokenSlot(token common.Address, input []byte, slot common.Hash) (bool, error) {
+	snap := f.Snapshot()
+	defer f.RevertToSnapshot(snap)
+
+	f.SetStorageAt(token, slot, probeValue)
+	_, ret, err := f.tokenCall(token, input)
+	if err != nil {
+		return false, err
+	}
+	return len(ret) >= 32 && common.BytesToHash(ret[:32]) == probeValue, nil
+}
+
+// tokenCall runs a view call on token and returns the slots it loaded from
+// token's storage. The EVM's call snapshot is only released on failure, so the
+// call runs inside one of ours to leave the journal as it was.
+func (f *StateFork) tokenCall(token common.Address, input []byte) (map[common.Hash]bool, []byte, error) {
+	snap := f.Snapshot()
+	defer f.RevertToSnapshot(snap)
+
+	reads := make(map[common.Hash]bool)
+	hooks := &tracing.Hooks{
+		OnOpcode: func(pc uint64, op byte, gas, cost uint64, scope tracing.OpContext, rData []byte, depth int, err error) {
+			// a delegatecalled implementation still reads the proxy's storage
+			if vm.OpCode(op) != vm.SLOAD || scope.Address() != token {
+				return
+			}
+			if stack := scope.StackData(); len(stack) > 0 {
+				reads[common.Hash(stack[len(stack)-1].Bytes32())] = true
+			}
+		},
+	}
+
+	e := NewExecutor(f)
+	evm := vm.NewEVM(e.blockContext(f.BlockContext()), NewForkedStateDB(f), e.config, vm.Config{Tracer: hooks})
+	ret, _, err := evm.StaticCall(probeOwner, token, input, tokenCallGas)
+	if err != nil {
+		return nil, nil, fmt.Errorf("call failed: %w", err)
+	}
+	return reads, ret, nil
+}
+
+// TokenBalanceSlot is the storage slot holding holder's balance of token
+func (f *StateFork) TokenBalanceSlot(token, holder common.Address) (common.Hash, error) {
+	slots, err := f.DiscoverTokenSlots(token)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return layoutSlot(slots.BalanceSlot, slots.BalanceVyper, holder), nil
+}
+
+// TokenAllowanceSlot is the storage slot holding what spender may move of owner's token
+func (f *StateFork) TokenAllowanceSlot(token, owner, spender common.Address) (common.Hash, error) {
+	slots, err := f.DiscoverTokenSlots(token)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return layoutSlot(slots.AllowanceSlot, slots.AllowanceVyper, owner, spender), nil
+}
+
+// FundToken sets holder's token balance to amount. Total supply is left alone.
+func (f *StateFork) FundToken(token, holder common.Address, amount *big.Int) error {
+	slot, err := f.TokenBalanceSlot(token, holder)
+	if err != nil {
+		return err
+	}
+	f.SetStorageAt(token, slot, common.BigToHash(amount))
+	return nil
+}
+
+// ApproveToken sets spender's allowance over owner's token to amount
+func (f *StateFork) ApproveToken(token, owner, spender common.Address, amount *big.Int) error {
+	slot, err := f.TokenAllowanceSlot(token, owner, spender)
+	if err != nil {
+		return err
+	}
+	f.SetStorageAt(token, slot, common.BigToHash(amount))
+	return nil
+}
@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+// tokenCode is a minimal ERC-20 answering allowance(address,address) and,
+// for any other selector, balanceOf(address), with the mappings declared at
+// the given slots in Solidity or Vyper layout
+func tokenCode(balanceSlot, allowanceSlot byte, vyper bool) []byte {
+	// push key, push slot, store them in hashing order at 0 and 32
+	pair := func(key, slot string) string {
+		if vyper {
+			return slot + "600052" + key + "602052"
+		}
+		return key + "600052" + slot + "602052"
+	}
+	hash := "6040600020"                  // keccak256(0, 64)
+	ret := "54" + "600052" + "60206000f3" // return sload(h)
+
+	balanceOf := pair("600435", fmt.Sprintf("60%02x", balanceSlot)) + hash + ret
+	// inner hash of owner, then hash it with spender the same way
+	allowance := pair("600435", fmt.Sprintf("60%02x", allowanceSlot)) + hash
+	if vyper {
+		allowance += "600052" + "602435602052"
+	} else {
+		allowance += "602052" + "602435600052"
+	}
+	allowance += hash + ret
+
+	// selector == allowance ? jump : fall through to balanceOf
+	dispatch := "60003560e01c" + "63dd62ed3e" + "14" + "60%02x" + "57"
+	dest := 15 + len(balanceOf)/2
+	code := fmt.Sprintf(dispatch, dest) + balanceOf + "5b" + allowance
+	return common.FromHex(code)
+}
+
+func TestDiscoverTokenSlots(t *testing.T) {
+	solToken := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	vyToken := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	holder := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	spender := common.HexToAddress("0x4444444444444444444444444444444444444444")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetCode(testBlock, solToken, tokenCode(3, 4, false))
+		n.SetCode(testBlock, vyToken, tokenCode(7, 2, true))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	for _, tc := range []struct {
+		token                  common.Address
+		balanceSlot, allowSlot uint64
+		vyper                  bool
+	}{
+		{solToken, 3, 4, false},
+		{vyToken, 7, 2, true},
+	} {
+		slots, err := fork.DiscoverTokenSlots(tc.token)
+		if err != nil {
+			t.Fatalf("DiscoverTokenSlots(%s): %v", tc.token.Hex(), err)
+		}
+		if slots.BalanceSlot != tc.balanceSlot || slots.AllowanceSlot != tc.allowSlot ||
+			slots.BalanceVyper != tc.vyper || slots.AllowanceVyper != tc.vyper {
+			t.Errorf("%s: slots = %+v", tc.token.Hex(), slots)
+		}
+		if cached, ok := fork.db.GetTokenSlots(tc.token); !ok || *cached != *slots {
+			t.Errorf("%s: cached slots = %+v", tc.token.Hex(), cached)
+		}
+		if len(fork.journal.revisions) != 0 || len(fork.journal.entries) != 0 {
+			t.Errorf("%s: discovery left %d revisions, %d entries in the journal", tc.token.Hex(), len(fork.journal.revisions), len(fork.journal.entries))
+		}
+
+		// funding and approving show up through the token's own getters
+		if err := fork.FundToken(tc.token, holder, big.NewInt(5e18)); err != nil {
+			t.Fatalf("FundToken: %v", err)
+		}
+		if err := fork.ApproveToken(tc.token, holder, spender, big.NewInt(77)); err != nil {
+			t.Fatalf("ApproveToken: %v", err)
+		}
+		executor := NewExecutor(fork)
+		call := func(data string) *big.Int {
+			r, err := executor.ExecuteCall(ethereum.CallMsg{To: &tc.token, Data: common.FromHex(data)}, block)
+			if err != nil || !r.Success {
+				t.Fatalf("call %s: %v %+v", data[:8], err, r)
+			}
+			return new(big.Int).SetBytes(r.ReturnData)
+		}
+		word := func(a common.Address) string { return common.Bytes2Hex(common.LeftPadBytes(a.Bytes(), 32)) }
+		if bal := call("70a08231" + word(holder)); bal.Cmp(big.NewInt(5e18)) != 0 {
+			t.Errorf("%s: balanceOf = %s, want 5e18", tc.token.Hex(), bal)
+		}
+		if allowed := call("dd62ed3e" + word(holder) + word(spender)); allowed.Int64() != 77 {
+			t.Errorf("%s: allowance = %s, want 77", tc.token.Hex(), allowed)
+		}
+	}
+
+	// discovery leaves no writes behind
+	if v, _ := fork.GetStorageAt(solToken, layoutSlot(3, false, probeOwner)); v != (common.Hash{}) {
+		t.Errorf("probe balance left at %s", v.Hex())
+	}
+
+	// an account without code can't be a token
+	if _, err := fork.DiscoverTokenSlots(holder); err == nil {
+		t.Error("discovery on an EOA succeeded")
+	}
+}
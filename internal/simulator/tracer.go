@@ -0,0 +1,48 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/params"
+
+	// registers callTracer, 4byteTracer, prestateTracer, ...
+	_ "github.com/ethereum/go-ethereum/eth/tracers/native"
+)
+
+// StructLogger is the opcode logger debug_traceTransaction uses when no
+// tracer is named
+const StructLogger = "structLogger"
+
+// TracerNames are the built-in tracers accepted by NewTracer
+var TracerNames = []string{"callTracer", "4byteTracer", "prestateTracer", StructLogger}
+
+// NewTracer builds one of geth's tracers by name. cfg is the tracerConfig of a
+// debug_traceTransaction request (e.g. {"withLog": true} for callTracer) and
+// may be nil. Results match what a node returns for the same tracer.
+func NewTracer(name string, cfg json.RawMessage, txCtx *tracers.Context) (*tracers.Tracer, error) {
+	if name == "" || name == StructLogger {
+		var config logger.Config
+		if len(cfg) > 0 {
+			if err := json.Unmarshal(cfg, &config); err != nil {
+				return nil, fmt.Errorf("invalid %s config: %w", StructLogger, err)
+			}
+		}
+		l := logger.NewStructLogger(&config)
+		return &tracers.Tracer{
+			Hooks:     l.Hooks(),
+			GetResult: l.GetResult,
+			Stop:      l.Stop,
+		}, nil
+	}
+	if txCtx == nil {
+		txCtx = new(tracers.Context)
+	}
+	tracer, err := tracers.DefaultDirectory.New(name, txCtx, cfg, params.MainnetChainConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracer %s: %w", name, err)
+	}
+	return tracer, nil
+}
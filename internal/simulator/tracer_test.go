@@ -0,0 +1,108 @@
+package simulator
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pulkyeet/mev-searcher/internal/testnode"
+)
+
+func TestTracers(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	router := common.HexToAddress("0x8888888888888888888888888888888888888888")
+	pool := common.HexToAddress("0x9999999999999999999999999999999999999999")
+
+	// router: call(gas, pool, 0, 0, 0, 0, 0), ignore the result; pool: revert(0, 0)
+	routerCode := common.FromHex("6000600060006000600073" + strings.TrimPrefix(strings.ToLower(pool.Hex()), "0x") + "5af1" + "50" + "00")
+
+	node, fork := newTestFork(t, testBlock, func(n *testnode.Node) {
+		n.SetBalance(testBlock, sender, big.NewInt(1e18))
+		n.SetCode(testBlock, router, routerCode)
+		n.SetCode(testBlock, pool, common.FromHex("60006000fd"))
+		n.AddBlock(testBlock, nil, nil)
+	})
+	block := types.NewBlockWithHeader(node.Header(testBlock + 1))
+
+	signer := types.LatestSignerForChainID(big.NewInt(1))
+	newTx := func(nonce uint64) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(20e9), Gas: 100000, To: &router})
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return tx
+	}
+
+	executor := NewExecutor(fork)
+	callTracer, err := NewTracer("callTracer", nil, nil)
+	if err != nil {
+		t.Fatalf("NewTracer: %v", err)
+	}
+	executor.SetTracer(callTracer.Hooks)
+	result, err := executor.ExecuteTransaction(newTx(0), block)
+	if err != nil || !result.Success {
+		t.Fatalf("ExecuteTransaction: %v %+v", err, result)
+	}
+
+	raw, err := callTracer.GetResult()
+	if err != nil {
+		t.Fatalf("GetResult: %v", err)
+	}
+	var frame struct {
+		Type    string         `json:"type"`
+		To      common.Address `json:"to"`
+		GasUsed hexutil.Uint64 `json:"gasUsed"`
+		Calls   []struct {
+			To    common.Address `json:"to"`
+			Error string         `json:"error"`
+		} `json:"calls"`
+	}
+	if err := json.Unmarshal(raw, &frame); err != nil {
+		t.Fatalf("decode call trace: %v", err)
+	}
+	if frame.Type != "CALL" || frame.To != router || uint64(frame.GasUsed) != result.GasUsed {
+		t.Errorf("top frame = %s", raw)
+	}
+	// the inner revert is visible even though the tx succeeded
+	if len(frame.Calls) != 1 || frame.Calls[0].To != pool || frame.Calls[0].Error != "execution reverted" {
+		t.Errorf("inner calls = %s", raw)
+	}
+
+	structLogger, err := NewTracer(StructLogger, nil, nil)
+	if err != nil {
+		t.Fatalf("NewTracer: %v", err)
+	}
+	executor.SetTracer(structLogger.Hooks)
+	if _, err := executor.ExecuteTransaction(newTx(1), block); err != nil {
+		t.Fatalf("ExecuteTransaction: %v", err)
+	}
+	raw, err = structLogger.GetResult()
+	if err != nil {
+		t.Fatalf("GetResult: %v", err)
+	}
+	var logs struct {
+		Gas        uint64 `json:"gas"`
+		StructLogs []struct {
+			Op    string `json:"op"`
+			Depth int    `json:"depth"`
+		} `json:"structLogs"`
+	}
+	if err := json.Unmarshal(raw, &logs); err != nil {
+		t.Fatalf("decode struct logs: %v", err)
+	}
+	var sawRevert bool
+	for _, l := range logs.StructLogs {
+		if l.Op == "REVERT" && l.Depth == 2 {
+			sawRevert = true
+		}
+	}
+	if logs.Gas != result.GasUsed || !sawRevert {
+		t.Errorf("struct logs: gas=%d ops=%d revert at depth 2=%v", logs.Gas, len(logs.StructLogs), sawRevert)
+	}
+}
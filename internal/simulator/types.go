@@ -1,9 +1,11 @@
 package simulator
 
 import (
+	"fmt"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
     "github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -13,7 +15,56 @@ type SimulationResult struct {
 	Logs []*types.Log
 	ReturnData []byte
 	RevertReason string
+	// Err is why it failed: the EVM error (vm.ErrExecutionReverted, ...) if the
+	// tx was included, the consensus error (core.ErrNonceTooLow, ...) if not
+	Err error
 	StateChanges *StateChanges
+
+	// Fee accounting, all in wei
+	EffectiveGasPrice *big.Int // min(feeCap, baseFee + tipCap), gas price for legacy txs
+	PriorityFee *big.Int // per gas, goes to the coinbase
+	GasFees *big.Int // gasUsed * PriorityFee
+	BurntFees *big.Int // gasUsed * baseFee, plus BlobFees
+	BlobGasUsed uint64
+	BlobFees *big.Int // blobGasUsed * blobBaseFee, burnt
+	CoinbaseDiff *big.Int // coinbase balance change: GasFees plus any direct payment
+
+	tracker *StateTracker // pre-state of this tx, merged into bundle diffs
+}
+
+// BundleOptions are the relay bundle fields that decide where a bundle may
+// land. Zero values leave that side unconstrained.
+type BundleOptions struct {
+	RevertingTxHashes []common.Hash // txs that may revert, or be dropped, without failing the bundle
+	MinTimestamp uint64
+	MaxTimestamp uint64
+	MinBlock uint64 // target block range, inclusive
+	MaxBlock uint64
+}
+
+// AllowsRevert reports whether tx hash is in RevertingTxHashes
+func (o *BundleOptions) AllowsRevert(hash common.Hash) bool {
+	for _, h := range o.RevertingTxHashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBlock says why the bundle can't go into block, or "" if it can
+func (o *BundleOptions) checkBlock(block *types.Block) string {
+	switch num, ts := block.NumberU64(), block.Time(); {
+	case o.MinBlock > 0 && num < o.MinBlock:
+		return fmt.Sprintf("block %d is before target range start %d", num, o.MinBlock)
+	case o.MaxBlock > 0 && num > o.MaxBlock:
+		return fmt.Sprintf("block %d is after target range end %d", num, o.MaxBlock)
+	case o.MinTimestamp > 0 && ts < o.MinTimestamp:
+		return fmt.Sprintf("block timestamp %d is before minTimestamp %d", ts, o.MinTimestamp)
+	case o.MaxTimestamp > 0 && ts > o.MaxTimestamp:
+		return fmt.Sprintf("block timestamp %d is after maxTimestamp %d", ts, o.MaxTimestamp)
+	}
+	return ""
 }
 
 type BundleResult struct {
@@ -21,6 +72,82 @@ type BundleResult struct {
 	Transactions []*TxResult
 	TotalGasUsed uint64
 	RevertedAt int // -1 if all tx succeed; txIndex if a tx fails
+	Options BundleOptions
+	Invalid string // set when Options rule out the block; nothing was executed
+	StateChanges *StateChanges // whole bundle, up to and including a failed tx
+
+	// what the builder receives for the bundle, in wei
+	CoinbaseDiff *big.Int
+	GasFees *big.Int
+	BurntFees *big.Int
+}
+
+func newBundleResult(size int) *BundleResult {
+	return &BundleResult{
+		Success: true,
+		Transactions: make([]*TxResult, 0, size),
+		RevertedAt: -1,
+		CoinbaseDiff: new(big.Int),
+		GasFees: new(big.Int),
+		BurntFees: new(big.Int),
+	}
+}
+
+// add accounts one executed bundle tx
+func (r *BundleResult) add(tx *types.Transaction, sim *SimulationResult, tracker *StateTracker) {
+	if sim.tracker != nil {
+		tracker.merge(sim.tracker)
+	}
+	r.Transactions = append(r.Transactions, newTxResult(tx, sim))
+	r.TotalGasUsed += sim.GasUsed
+	if sim.CoinbaseDiff != nil {
+		r.CoinbaseDiff.Add(r.CoinbaseDiff, sim.CoinbaseDiff)
+		r.GasFees.Add(r.GasFees, sim.GasFees)
+		r.BurntFees.Add(r.BurntFees, sim.BurntFees)
+	}
+}
+
+// EthSentToCoinbase is the part of the coinbase payment made by direct
+// transfers rather than priority fees
+func (r *BundleResult) EthSentToCoinbase() *big.Int {
+	return new(big.Int).Sub(r.CoinbaseDiff, r.GasFees)
+}
+
+// BuildResult is a block packed by BlockBuilder
+type BuildResult struct {
+	BlockNumber uint64
+	Transactions []*TxResult // in block order
+	Bundles []*BundleInclusion // one per candidate, in the order given
+	Dropped []DroppedTx
+	GasUsed uint64
+
+	// what the block is worth to the builder, in wei
+	CoinbaseDiff *big.Int
+	GasFees *big.Int
+	BurntFees *big.Int
+}
+
+// BundleInclusion is what became of one candidate bundle
+type BundleInclusion struct {
+	Included bool
+	Position int // block index of its first tx, -1 if left out
+	GasPrice *big.Int // coinbase payment per gas at the top of the block, what it was ranked by
+	Reason string // why it was left out
+	Result *BundleResult // its run inside the block, or at the top of it if it never got that far
+}
+
+// DroppedTx is a mempool tx that couldn't go into the block
+type DroppedTx struct {
+	TxHash common.Hash
+	Reason string
+}
+
+// AdvanceResult reports one block applied by StateFork.Advance
+type AdvanceResult struct {
+	BlockNumber uint64
+	Transactions []*TxResult
+	GasUsed uint64
+	Mismatches []ReceiptMismatch
 }
 
 type TxResult struct {
@@ -30,13 +157,46 @@ type TxResult struct {
 	Logs []*types.Log
 	ReturnData []byte
 	RevertReason string
+	Err error
+
+	EffectiveGasPrice *big.Int
+	GasFees *big.Int
+	CoinbaseDiff *big.Int
+	StateChanges *StateChanges
+}
+
+func newTxResult(tx *types.Transaction, r *SimulationResult) *TxResult {
+	return &TxResult{
+		TxHash: tx.Hash(),
+		Success: r.Success,
+		GasUsed: r.GasUsed,
+		Logs: r.Logs,
+		ReturnData: r.ReturnData,
+		RevertReason: r.RevertReason,
+		Err: r.Err,
+		EffectiveGasPrice: r.EffectiveGasPrice,
+		GasFees: r.GasFees,
+		CoinbaseDiff: r.CoinbaseDiff,
+		StateChanges: r.StateChanges,
+	}
 }
 
+// StateChanges is a state diff in the format of geth's prestateTracer with
+// diffMode: Pre holds the touched accounts before execution, Post the fields
+// that changed. Accounts that were deleted are missing from Post, accounts
+// created from nothing are missing from Pre.
 type StateChanges struct {
-	BalanceChanges map[common.Address]*big.Int
-	NonceChanges map[common.Address]uint64
-	StorageChanges map[common.Address]map[common.Hash]common.Hash
-	CodeChanges map[common.Address][]byte
+	Pre map[common.Address]*AccountState `json:"pre"`
+	Post map[common.Address]*AccountState `json:"post"`
+}
+
+// AccountState is one account of a StateChanges side
+type AccountState struct {
+	Balance *hexutil.Big `json:"balance,omitempty"`
+	Code hexutil.Bytes `json:"code,omitempty"`
+	CodeHash *common.Hash `json:"codeHash,omitempty"`
+	Nonce uint64 `json:"nonce,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
 }
 
 type StateCache struct {
@@ -44,6 +204,17 @@ type StateCache struct {
 	nonces map[common.Address]uint64
 	code map[common.Address][]byte
 	storage map[common.Address]map[common.Hash]common.Hash
+
+	// EIP-1153 transient storage, lives for one transaction
+	transient map[common.Address]map[common.Hash]common.Hash
+
+	// storage wiped by a (re)creation or deletion, never read through to the backend
+	cleared map[common.Address]bool
+
+	// account lifecycle within the current transaction
+	created    map[common.Address]bool // contract created in this tx (EIP-6780)
+	destructed map[common.Address]bool
+	touched    map[common.Address]bool // candidates for EIP-161 empty account removal
 }
 
 func NewStateCache() *StateCache {
@@ -52,22 +223,26 @@ func NewStateCache() *StateCache {
         nonces:   make(map[common.Address]uint64),
         code:     make(map[common.Address][]byte),
         storage:  make(map[common.Address]map[common.Hash]common.Hash),
+        transient: make(map[common.Address]map[common.Hash]common.Hash),
+        cleared:    make(map[common.Address]bool),
+        created:    make(map[common.Address]bool),
+        destructed: make(map[common.Address]bool),
+        touched:    make(map[common.Address]bool),
     }
 }
 
+// StateTracker records the pre-state of every account and slot written during
+// execution, see statediff.go
 type StateTracker struct {
 	fork *StateFork
-	changes *StateChanges
+	pre map[common.Address]*AccountState
+	created map[common.Address]bool
 }
 
 func NewStateTracker(fork *StateFork) *StateTracker {
 	return &StateTracker{
 		fork: fork,
-		changes: &StateChanges{
-			BalanceChanges: make(map[common.Address]*big.Int),
-			NonceChanges:   make(map[common.Address]uint64),
-			StorageChanges: make(map[common.Address]map[common.Hash]common.Hash),
-			CodeChanges:    make(map[common.Address][]byte),
-		},
+		pre: make(map[common.Address]*AccountState),
+		created: make(map[common.Address]bool),
 	}
-}
\ No newline at end of file
+}
@@ -49,7 +49,7 @@ func (c *CacheDB) Close() error {
 
 // Account State operations
 func (c *CacheDB) GetBalance(blockNumber uint64, addr common.Address) (*big.Int, bool) {
-	var balanceStr string
+	var balanceStr sql.NullString
 	err := c.db.QueryRow(
 		"SELECT balance FROM account_state WHERE block_number=? AND address=?",
 		blockNumber, addr.Hex(),
@@ -58,43 +58,46 @@ func (c *CacheDB) GetBalance(blockNumber uint64, addr common.Address) (*big.Int,
 	if err == sql.ErrNoRows {
 		return nil, false
 	}
-	if err != nil {
+	// row exists but only nonce/code were cached so far
+	if err != nil || !balanceStr.Valid {
 		return nil, false
 	}
 
 	balance := new(big.Int)
-	balance.SetString(balanceStr, 10)
+	balance.SetString(balanceStr.String, 10)
 	return balance, true
 }
 
 func (c *CacheDB) SetBalance(blockNumber uint64, addr common.Address, balance *big.Int) error {
 	_, err := c.db.Exec(
-		"INSERT OR REPLACE INTO account_state (block_number, address, balance) VALUES (? , ?, ?)",
+		"INSERT INTO account_state (block_number, address, balance) VALUES (?, ?, ?) "+
+			"ON CONFLICT(block_number, address) DO UPDATE SET balance = excluded.balance",
 		blockNumber, addr.Hex(), balance.String(),
 	)
 	return err
 }
 
 func (c *CacheDB) GetNonce(blockNumber uint64, addr common.Address) (uint64, bool) {
-	var nonce uint64
+	var nonce sql.NullInt64
 	err := c.db.QueryRow(
-		"SELECT nonce FROM account_state WHERE block_numer = ? AND address = ?",
+		"SELECT nonce FROM account_state WHERE block_number = ? AND address = ?",
 		blockNumber, addr.Hex(),
 	).Scan(&nonce)
 
 	if err == sql.ErrNoRows {
 		return 0, false
 	}
-	if err != nil {
+	if err != nil || !nonce.Valid {
 		return 0, false
 	}
 
-	return nonce, true
+	return uint64(nonce.Int64), true
 }
 
 func (c *CacheDB) SetNonce(blockNumber uint64, addr common.Address, nonce uint64) error {
 	_, err := c.db.Exec(
-		"INSERT OR REPLACE INTO account_state (block_number, address, nonce) VALUES (?, ?, ?)",
+		"INSERT INTO account_state (block_number, address, nonce) VALUES (?, ?, ?) "+
+			"ON CONFLICT(block_number, address) DO UPDATE SET nonce = excluded.nonce",
 		blockNumber, addr.Hex(), nonce,
 	)
 	return err
@@ -102,15 +105,17 @@ func (c *CacheDB) SetNonce(blockNumber uint64, addr common.Address, nonce uint64
 
 func (c *CacheDB) GetCode(blockNumber uint64, addr common.Address) ([]byte, bool) {
 	var code []byte
+	var known bool
 	err := c.db.QueryRow(
-		"SELECT code FROM account_state WHERE block_number = ? AND address = ?",
+		"SELECT code IS NOT NULL, code FROM account_state WHERE block_number = ? AND address = ?",
 		blockNumber, addr.Hex(),
-	).Scan(&code)
+	).Scan(&known, &code)
 
 	if err == sql.ErrNoRows {
 		return nil, false
 	}
-	if err != nil {
+	// NULL means the code was never fetched, an empty blob means an EOA
+	if err != nil || !known {
 		return nil, false
 	}
 
@@ -119,12 +124,21 @@ func (c *CacheDB) GetCode(blockNumber uint64, addr common.Address) ([]byte, bool
 
 func (c *CacheDB) SetCode(blockNumber uint64, addr common.Address, code []byte) error {
 	_, err := c.db.Exec(
-		"INSERT OR REPLACE INTO account_state (block_number, address, code) VALUES (?, ?, ?)",
-		blockNumber, addr.Hex(), code,
+		"INSERT INTO account_state (block_number, address, code) VALUES (?, ?, ?) "+
+			"ON CONFLICT(block_number, address) DO UPDATE SET code = excluded.code",
+		blockNumber, addr.Hex(), nonNilCode(code),
 	)
 	return err
 }
 
+// nonNilCode makes sure "no code" is stored as an empty blob, not NULL
+func nonNilCode(code []byte) []byte {
+	if code == nil {
+		return []byte{}
+	}
+	return code
+}
+
 // Storage operations
 func (c *CacheDB) GetStorage(blockNumber uint64, addr common.Address, slot common.Hash) (common.Hash, bool) {
 	var valueHex string
@@ -151,6 +165,58 @@ func (c *CacheDB) SetStorage(blockNumber uint64, addr common.Address, slot, valu
 	return err
 }
 
+// Block hash operations
+func (c *CacheDB) GetBlockHash(blockNumber uint64) (common.Hash, bool) {
+	var hashHex string
+	err := c.db.QueryRow(
+		"SELECT hash FROM block_hashes WHERE block_number = ?",
+		blockNumber,
+	).Scan(&hashHex)
+
+	if err != nil {
+		return common.Hash{}, false
+	}
+	return common.HexToHash(hashHex), true
+}
+
+func (c *CacheDB) SetBlockHash(blockNumber uint64, hash common.Hash) error {
+	_, err := c.db.Exec(
+		"INSERT OR REPLACE INTO block_hashes (block_number, hash) VALUES (?, ?)",
+		blockNumber, hash.Hex(),
+	)
+	return err
+}
+
+// TokenSlots is where an ERC-20 keeps its balanceOf and allowance mappings.
+// Vyper layouts hash the slot before the key instead of after it.
+type TokenSlots struct {
+	BalanceSlot    uint64
+	BalanceVyper   bool
+	AllowanceSlot  uint64
+	AllowanceVyper bool
+}
+
+func (c *CacheDB) GetTokenSlots(token common.Address) (*TokenSlots, bool) {
+	var s TokenSlots
+	err := c.db.QueryRow(
+		"SELECT balance_slot, balance_vyper, allowance_slot, allowance_vyper FROM token_slots WHERE address = ?",
+		token.Hex(),
+	).Scan(&s.BalanceSlot, &s.BalanceVyper, &s.AllowanceSlot, &s.AllowanceVyper)
+
+	if err != nil {
+		return nil, false
+	}
+	return &s, true
+}
+
+func (c *CacheDB) SetTokenSlots(token common.Address, s *TokenSlots) error {
+	_, err := c.db.Exec(
+		"INSERT OR REPLACE INTO token_slots (address, balance_slot, balance_vyper, allowance_slot, allowance_vyper) VALUES (?, ?, ?, ?, ?)",
+		token.Hex(), s.BalanceSlot, s.BalanceVyper, s.AllowanceSlot, s.AllowanceVyper,
+	)
+	return err
+}
+
 // Batch operations for prewarming
 
 type AccountData struct {
@@ -181,10 +247,10 @@ func (c *CacheDB) BatchSetAccounts(blockNumber uint64, accounts []AccountData) e
 			acc.Address.Hex(),
 			acc.Balance.String(),
 			acc.Nonce,
-			acc.Code,
+			nonNilCode(acc.Code),
 		)
 		if err != nil {
-			return nil
+			return err
 		}
 	}
 	return tx.Commit()
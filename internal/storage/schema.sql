@@ -23,6 +23,22 @@ CREATE TABLE IF NOT EXISTS storage_state (
 CREATE INDEX IF NOT EXISTS idx_storage_block ON storage_state(block_number);
 CREATE INDEX IF NOT EXISTS idx_storage_address ON storage_state(address);
 
+-- Canonical block hashes (for BLOCKHASH), immutable so not keyed by fork block
+CREATE TABLE IF NOT EXISTS block_hashes (
+    block_number INTEGER PRIMARY KEY,
+    hash TEXT NOT NULL
+);
+
+-- ERC-20 balanceOf / allowance mapping slots, found by DiscoverTokenSlots.
+-- A layout doesn't depend on the block, so these aren't keyed by it.
+CREATE TABLE IF NOT EXISTS token_slots (
+    address TEXT PRIMARY KEY,
+    balance_slot INTEGER NOT NULL,
+    balance_vyper INTEGER NOT NULL,
+    allowance_slot INTEGER NOT NULL,
+    allowance_vyper INTEGER NOT NULL
+);
+
 -- Metadata for cache stats
 CREATE TABLE IF NOT EXISTS cache_metadata (
     key TEXT PRIMARY KEY,
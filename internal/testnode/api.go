@@ -0,0 +1,274 @@
+package testnode
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/holiman/uint256"
+)
+
+// ethAPI implements the eth_ namespace
+type ethAPI struct {
+	n *Node
+}
+
+func (api *ethAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(api.n.config.ChainID)
+}
+
+func (api *ethAPI) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(api.n.Head())
+}
+
+func (api *ethAPI) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	num, err := api.n.resolveBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := api.n.blocks[num]
+	if !ok {
+		return nil, nil // JSON null, ethclient turns it into ethereum.NotFound
+	}
+	return api.n.marshalBlock(block, fullTx)
+}
+
+func (api *ethAPI) lookup(addr common.Address, number rpc.BlockNumber) (*Account, error) {
+	num, err := api.n.resolveBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	if acc, ok := api.n.stateAt(num)[addr]; ok {
+		return acc, nil
+	}
+	return &Account{Balance: new(big.Int)}, nil
+}
+
+func (api *ethAPI) GetBalance(addr common.Address, number rpc.BlockNumber) (*hexutil.Big, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	acc, err := api.lookup(addr, number)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(new(big.Int).Set(acc.Balance)), nil
+}
+
+func (api *ethAPI) GetTransactionCount(addr common.Address, number rpc.BlockNumber) (hexutil.Uint64, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	acc, err := api.lookup(addr, number)
+	if err != nil {
+		return 0, err
+	}
+	return hexutil.Uint64(acc.Nonce), nil
+}
+
+func (api *ethAPI) GetCode(addr common.Address, number rpc.BlockNumber) (hexutil.Bytes, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	acc, err := api.lookup(addr, number)
+	if err != nil {
+		return nil, err
+	}
+	return common.CopyBytes(acc.Code), nil
+}
+
+func (api *ethAPI) GetStorageAt(addr common.Address, slot common.Hash, number rpc.BlockNumber) (hexutil.Bytes, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	acc, err := api.lookup(addr, number)
+	if err != nil {
+		return nil, err
+	}
+	val := acc.Storage[slot]
+	return val.Bytes(), nil
+}
+
+func (api *ethAPI) GetTransactionReceipt(hash common.Hash) (*types.Receipt, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	loc, ok := api.n.txs[hash]
+	if !ok {
+		return nil, nil
+	}
+	return api.n.receipts[loc.block][loc.index], nil
+}
+
+func (api *ethAPI) GetBlockReceipts(number rpc.BlockNumber) ([]*types.Receipt, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	num, err := api.n.resolveBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := api.n.blocks[num]; !ok {
+		return nil, nil
+	}
+	return api.n.receipts[num], nil
+}
+
+// callArgs is the eth_call transaction object
+type callArgs struct {
+	From  *common.Address `json:"from"`
+	To    *common.Address `json:"to"`
+	Gas   *hexutil.Uint64 `json:"gas"`
+	Value *hexutil.Big    `json:"value"`
+	Data  *hexutil.Bytes  `json:"data"`
+	Input *hexutil.Bytes  `json:"input"`
+}
+
+// revertError mirrors geth's eth_call revert error (code 3 + return data)
+type revertError struct {
+	reason string
+	data   hexutil.Bytes
+}
+
+func (e *revertError) Error() string          { return e.reason }
+func (e *revertError) ErrorCode() int         { return 3 }
+func (e *revertError) ErrorData() interface{} { return e.data }
+
+// Call runs the message in a real EVM on top of the seeded state
+func (api *ethAPI) Call(args callArgs, number rpc.BlockNumber) (hexutil.Bytes, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	num, err := api.n.resolveBlock(number)
+	if err != nil {
+		return nil, err
+	}
+
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabaseForTesting())
+	if err != nil {
+		return nil, err
+	}
+	for addr, acc := range api.n.stateAt(num) {
+		bal, _ := uint256.FromBig(acc.Balance)
+		statedb.SetBalance(addr, bal, tracing.BalanceChangeUnspecified)
+		statedb.SetNonce(addr, acc.Nonce, tracing.NonceChangeUnspecified)
+		statedb.SetCode(addr, acc.Code, tracing.CodeChangeUnspecified)
+		for k, v := range acc.Storage {
+			statedb.SetState(addr, k, v)
+		}
+	}
+
+	header, ok := api.n.blocks[num]
+	var h *types.Header
+	if ok {
+		h = header.Header()
+	} else {
+		h = api.n.defaultHeader(num)
+	}
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash: func(n uint64) common.Hash {
+			if b, ok := api.n.blocks[n]; ok {
+				return b.Hash()
+			}
+			return common.Hash{}
+		},
+		Coinbase:    h.Coinbase,
+		BlockNumber: h.Number,
+		Time:        h.Time,
+		Difficulty:  h.Difficulty,
+		GasLimit:    h.GasLimit,
+		BaseFee:     new(big.Int),
+	}
+	evm := vm.NewEVM(blockCtx, statedb, api.n.config, vm.Config{NoBaseFee: true})
+
+	var from common.Address
+	if args.From != nil {
+		from = *args.From
+	}
+	var input []byte
+	if args.Input != nil {
+		input = *args.Input
+	} else if args.Data != nil {
+		input = *args.Data
+	}
+	gas := uint64(math.MaxUint64 / 2)
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	}
+	value := new(uint256.Int)
+	if args.Value != nil {
+		value, _ = uint256.FromBig((*big.Int)(args.Value))
+	}
+	if args.To == nil {
+		return nil, fmt.Errorf("testnode: contract creation via eth_call is not supported")
+	}
+
+	ret, _, err := evm.Call(from, *args.To, input, gas, value)
+	if err == vm.ErrExecutionReverted {
+		return nil, &revertError{reason: "execution reverted", data: ret}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// debugAPI implements the debug_ namespace
+type debugAPI struct {
+	n *Node
+}
+
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// TraceTransaction answers prestateTracer requests for txs registered with
+// SetPrestateTrace; the tracer config is ignored
+func (api *debugAPI) TraceTransaction(hash common.Hash, config map[string]interface{}) (map[common.Address]*prestateAccount, error) {
+	api.n.mu.RLock()
+	defer api.n.mu.RUnlock()
+
+	touched, ok := api.n.traces[hash]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not traceable", hash.Hex())
+	}
+	loc := api.n.txs[hash]
+	pre := api.n.stateAt(loc.block - 1)
+
+	out := make(map[common.Address]*prestateAccount, len(touched))
+	for addr, slots := range touched {
+		acc, ok := pre[addr]
+		if !ok {
+			acc = &Account{Balance: new(big.Int)}
+		}
+		entry := &prestateAccount{
+			Balance: (*hexutil.Big)(new(big.Int).Set(acc.Balance)),
+			Nonce:   acc.Nonce,
+			Code:    common.CopyBytes(acc.Code),
+		}
+		if len(slots) > 0 {
+			entry.Storage = make(map[common.Hash]common.Hash, len(slots))
+			for _, slot := range sortedSlots(slots) {
+				entry.Storage[slot] = acc.Storage[slot]
+			}
+		}
+		out[addr] = entry
+	}
+	return out, nil
+}
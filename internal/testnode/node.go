@@ -0,0 +1,409 @@
+// Package testnode is an in-process stand-in for an Ethereum archive node.
+//
+// It serves the subset of JSON-RPC the project uses (blocks, balances, code,
+// storage, nonces, eth_call, receipts, prestate traces) over HTTP on loopback,
+// backed by synthetic state seeded from Go. Point eth.DialClient at URL() and
+// the simulator, detector and backtester run end-to-end without a network.
+package testnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Synthetic chain defaults. Timestamps put block 18,000,000 at roughly its
+// mainnet time so mainnet fork rules line up with block numbers. Seed realistic
+// block numbers: low ones run under Frontier rules (no SHR, no PUSH0, ...).
+const (
+	genesisTime     = 1_484_000_000
+	blockTime       = 12
+	DefaultGasLimit = 30_000_000
+)
+
+var (
+	DefaultBaseFee  = big.NewInt(10e9) // 10 gwei
+	DefaultCoinbase = common.HexToAddress("0x95222290DD7278Aa3Ddd389Cc1E1d165CC4BAfe5")
+)
+
+// Account is the seeded state of one address
+type Account struct {
+	Balance *big.Int
+	Nonce   uint64
+	Code    []byte
+	Storage map[common.Hash]common.Hash
+}
+
+func (a *Account) copy() *Account {
+	cp := &Account{
+		Balance: new(big.Int).Set(a.Balance),
+		Nonce:   a.Nonce,
+		Code:    a.Code,
+		Storage: make(map[common.Hash]common.Hash, len(a.Storage)),
+	}
+	for k, v := range a.Storage {
+		cp.Storage[k] = v
+	}
+	return cp
+}
+
+// worldState is the full state after a block was applied
+type worldState map[common.Address]*Account
+
+func (ws worldState) copy() worldState {
+	cp := make(worldState, len(ws))
+	for addr, acc := range ws {
+		cp[addr] = acc.copy()
+	}
+	return cp
+}
+
+type txLocation struct {
+	block uint64
+	index int
+}
+
+// Node is the stand-in node. All seeding methods are safe to call while the
+// server is running.
+type Node struct {
+	mu       sync.RWMutex
+	config   *params.ChainConfig
+	states   map[uint64]worldState
+	blocks   map[uint64]*types.Block
+	receipts map[uint64][]*types.Receipt
+	txs      map[common.Hash]txLocation
+	traces   map[common.Hash]map[common.Address][]common.Hash
+	head     uint64
+
+	server *rpc.Server
+	http   *httptest.Server
+}
+
+// New starts a node listening on a loopback port. Call Close when done.
+func New() *Node {
+	n := &Node{
+		config:   params.MainnetChainConfig,
+		states:   make(map[uint64]worldState),
+		blocks:   make(map[uint64]*types.Block),
+		receipts: make(map[uint64][]*types.Receipt),
+		txs:      make(map[common.Hash]txLocation),
+		traces:   make(map[common.Hash]map[common.Address][]common.Hash),
+	}
+
+	n.server = rpc.NewServer()
+	if err := n.server.RegisterName("eth", &ethAPI{n}); err != nil {
+		panic(fmt.Sprintf("testnode: register eth api: %v", err))
+	}
+	if err := n.server.RegisterName("debug", &debugAPI{n}); err != nil {
+		panic(fmt.Sprintf("testnode: register debug api: %v", err))
+	}
+	n.http = httptest.NewServer(n.server)
+	return n
+}
+
+// URL is the HTTP endpoint to dial
+func (n *Node) URL() string {
+	return n.http.URL
+}
+
+func (n *Node) Close() {
+	n.http.Close()
+	n.server.Stop()
+}
+
+// Head is the highest block added so far
+func (n *Node) Head() uint64 {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.head
+}
+
+// stateAt returns the state after block num: the latest seeded state at or
+// below num. Callers must hold n.mu.
+func (n *Node) stateAt(num uint64) worldState {
+	best, found := uint64(0), false
+	for b := range n.states {
+		if b <= num && (!found || b > best) {
+			best, found = b, true
+		}
+	}
+	if !found {
+		return worldState{}
+	}
+	return n.states[best]
+}
+
+// mutableState returns the state for exactly block num, cloning the nearest
+// lower one on first write. Seed blocks in ascending order: changes made to
+// an earlier block later on are not propagated to blocks already seeded.
+func (n *Node) mutableState(num uint64) worldState {
+	if ws, ok := n.states[num]; ok {
+		return ws
+	}
+	ws := n.stateAt(num).copy()
+	n.states[num] = ws
+	return ws
+}
+
+func (n *Node) account(num uint64, addr common.Address) *Account {
+	ws := n.mutableState(num)
+	acc, ok := ws[addr]
+	if !ok {
+		acc = &Account{Balance: new(big.Int), Storage: make(map[common.Hash]common.Hash)}
+		ws[addr] = acc
+	}
+	return acc
+}
+
+// SetBalance seeds the balance of addr as of block num (and later blocks)
+func (n *Node) SetBalance(num uint64, addr common.Address, balance *big.Int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.account(num, addr).Balance = new(big.Int).Set(balance)
+}
+
+func (n *Node) SetNonce(num uint64, addr common.Address, nonce uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.account(num, addr).Nonce = nonce
+}
+
+func (n *Node) SetCode(num uint64, addr common.Address, code []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.account(num, addr).Code = common.CopyBytes(code)
+}
+
+func (n *Node) SetStorage(num uint64, addr common.Address, slot, value common.Hash) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.account(num, addr).Storage[slot] = value
+}
+
+// Account returns a copy of addr's state after block num
+func (n *Node) Account(num uint64, addr common.Address) *Account {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if acc, ok := n.stateAt(num)[addr]; ok {
+		return acc.copy()
+	}
+	return &Account{Balance: new(big.Int), Storage: make(map[common.Hash]common.Hash)}
+}
+
+// Header returns the default header used by AddBlock for block num
+func (n *Node) Header(num uint64) *types.Header {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.defaultHeader(num)
+}
+
+func (n *Node) defaultHeader(num uint64) *types.Header {
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(num),
+		Time:       genesisTime + blockTime*num,
+		GasLimit:   DefaultGasLimit,
+		BaseFee:    new(big.Int).Set(DefaultBaseFee),
+		Coinbase:   DefaultCoinbase,
+		Difficulty: new(big.Int),
+		MixDigest:  crypto.Keccak256Hash(new(big.Int).SetUint64(num).Bytes()),
+	}
+	if parent, ok := n.blocks[num-1]; ok {
+		header.ParentHash = parent.Hash()
+	}
+	// Cancun headers carry the blob gas fields; excess 0 means the minimum
+	// blob base fee
+	if n.config.IsCancun(header.Number, header.Time) {
+		header.ExcessBlobGas = new(uint64)
+		header.BlobGasUsed = new(uint64)
+	}
+	return header
+}
+
+// AddBlock adds block num with a default header (see Header)
+func (n *Node) AddBlock(num uint64, txs []*types.Transaction, receipts []*types.Receipt) *types.Block {
+	return n.AddBlockWithHeader(n.Header(num), txs, receipts)
+}
+
+// AddBlockWithHeader adds a block built from header, txs and receipts. Receipt
+// metadata (hashes, indexes, cumulative gas, bloom) is filled in; callers only
+// need to set Status, GasUsed and Logs. receipts may be nil.
+func (n *Node) AddBlockWithHeader(header *types.Header, txs []*types.Transaction, receipts []*types.Receipt) *types.Block {
+	return n.AddBlockWithBody(header, &types.Body{Transactions: txs}, receipts)
+}
+
+// AddBlockWithBody is AddBlockWithHeader for blocks that also carry withdrawals
+func (n *Node) AddBlockWithBody(header *types.Header, body *types.Body, receipts []*types.Receipt) *types.Block {
+	txs := body.Transactions
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if receipts == nil {
+		receipts = make([]*types.Receipt, len(txs))
+		for i := range receipts {
+			receipts[i] = &types.Receipt{Status: types.ReceiptStatusSuccessful, GasUsed: 21000}
+		}
+	}
+	if len(receipts) != len(txs) {
+		panic(fmt.Sprintf("testnode: %d receipts for %d txs", len(receipts), len(txs)))
+	}
+
+	// Fields that feed the block hash first, then the hash-dependent ones
+	var cumulative, blobGas uint64
+	for i, r := range receipts {
+		tx := txs[i]
+		cumulative += r.GasUsed
+		r.Type = tx.Type()
+		r.TxHash = tx.Hash()
+		r.CumulativeGasUsed = cumulative
+		r.TransactionIndex = uint(i)
+		if r.Logs == nil {
+			r.Logs = []*types.Log{} // receipts must always carry a logs array
+		}
+		if r.EffectiveGasPrice == nil {
+			r.EffectiveGasPrice = effectiveGasPrice(tx, header.BaseFee)
+		}
+		if r.BlobGasUsed == 0 {
+			r.BlobGasUsed = tx.BlobGas()
+		}
+		blobGas += r.BlobGasUsed
+		if tx.To() == nil {
+			if from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+				r.ContractAddress = crypto.CreateAddress(from, tx.Nonce())
+			}
+		}
+		r.Bloom = types.CreateBloom(r)
+	}
+	header.GasUsed = cumulative
+	if header.BlobGasUsed != nil {
+		header.BlobGasUsed = &blobGas
+	}
+
+	block := types.NewBlock(header, body, receipts, trie.NewStackTrie(nil))
+
+	logIndex := uint(0)
+	for i, r := range receipts {
+		r.BlockHash = block.Hash()
+		r.BlockNumber = new(big.Int).Set(block.Number())
+		for _, l := range r.Logs {
+			l.TxHash = r.TxHash
+			l.TxIndex = uint(i)
+			l.BlockHash = block.Hash()
+			l.BlockNumber = block.NumberU64()
+			l.Index = logIndex
+			logIndex++
+		}
+		n.txs[r.TxHash] = txLocation{block: block.NumberU64(), index: i}
+	}
+
+	num := block.NumberU64()
+	n.blocks[num] = block
+	n.receipts[num] = receipts
+	if num > n.head {
+		n.head = num
+	}
+	return block
+}
+
+// SetPrestateTrace makes debug_traceTransaction(txHash) report the given
+// addresses and slots as touched, with values from the state before the tx's block
+func (n *Node) SetPrestateTrace(txHash common.Hash, touched map[common.Address][]common.Hash) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.traces[txHash] = touched
+}
+
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return tx.GasPrice()
+	}
+	tip, err := tx.EffectiveGasTip(baseFee)
+	if err != nil {
+		// fee cap below base fee, such a tx could not have been included
+		return tx.GasFeeCap()
+	}
+	return new(big.Int).Add(tip, baseFee)
+}
+
+// resolveBlock maps an RPC block tag to a block number
+func (n *Node) resolveBlock(num rpc.BlockNumber) (uint64, error) {
+	switch num {
+	case rpc.LatestBlockNumber, rpc.PendingBlockNumber, rpc.SafeBlockNumber, rpc.FinalizedBlockNumber:
+		return n.head, nil
+	case rpc.EarliestBlockNumber:
+		return 0, nil
+	}
+	if num < 0 {
+		return 0, fmt.Errorf("unsupported block tag %d", num)
+	}
+	return uint64(num), nil
+}
+
+// marshalBlock renders a block the way eth_getBlockByNumber does
+func (n *Node) marshalBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	headerJSON, err := json.Marshal(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(headerJSON, &fields); err != nil {
+		return nil, err
+	}
+
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+		rpcTx, err := marshalTx(tx, block, i)
+		if err != nil {
+			return nil, err
+		}
+		txs[i] = rpcTx
+	}
+
+	fields["hash"] = block.Hash()
+	fields["transactions"] = txs
+	fields["uncles"] = []common.Hash{}
+	fields["size"] = fmt.Sprintf("0x%x", block.Size())
+	if block.Withdrawals() != nil {
+		fields["withdrawals"] = block.Withdrawals()
+	}
+	return fields, nil
+}
+
+func marshalTx(tx *types.Transaction, block *types.Block, index int) (map[string]interface{}, error) {
+	txJSON, err := tx.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(txJSON, &fields); err != nil {
+		return nil, err
+	}
+	if from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err == nil {
+		fields["from"] = from
+	}
+	fields["blockHash"] = block.Hash()
+	fields["blockNumber"] = fmt.Sprintf("0x%x", block.NumberU64())
+	fields["transactionIndex"] = fmt.Sprintf("0x%x", index)
+	return fields, nil
+}
+
+// sortedSlots gives deterministic output for traces
+func sortedSlots(slots []common.Hash) []common.Hash {
+	out := append([]common.Hash(nil), slots...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Cmp(out[j]) < 0 })
+	return out
+}
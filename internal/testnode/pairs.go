@@ -0,0 +1,72 @@
+package testnode
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Uniswap V2 pairs keep reserve0 (uint112), reserve1 (uint112) and
+// blockTimestampLast (uint32) packed into storage slot 8
+var PairReservesSlot = common.BigToHash(big.NewInt(8))
+
+// SwapTopic is the Uniswap V2 Swap(address,uint256,uint256,uint256,uint256,address) event
+var SwapTopic = common.HexToHash("0xd78ad95fa46c994b6551d0da85fc275fe613ce37657fb8d5e3d130840159d822")
+
+// pairCode is minimal runtime bytecode answering getReserves() the way a real
+// pair does: it unpacks slot 8 and returns the three words. Any selector works.
+var pairCode = common.FromHex(
+	"6008" + "54" + // SLOAD(8)
+		"80" + "6d" + "ffffffffffffffffffffffffffff" + "16" + "6000" + "52" + // mstore(0, v & mask112)
+		"80" + "6070" + "1c" + "6d" + "ffffffffffffffffffffffffffff" + "16" + "6020" + "52" + // mstore(32, (v >> 112) & mask112)
+		"60e0" + "1c" + "6040" + "52" + // mstore(64, v >> 224)
+		"6060" + "6000" + "f3", // return(0, 96)
+)
+
+// PairCode returns the stand-in pair runtime bytecode
+func PairCode() []byte {
+	return common.CopyBytes(pairCode)
+}
+
+// PackReserves builds the slot 8 word for the given reserves
+func PackReserves(reserve0, reserve1 *big.Int, timestamp uint32) common.Hash {
+	word := new(big.Int).Lsh(big.NewInt(int64(timestamp)), 224)
+	word.Or(word, new(big.Int).Lsh(reserve1, 112))
+	word.Or(word, reserve0)
+	return common.BigToHash(word)
+}
+
+// DeployPair places a Uniswap V2 pair at addr as of block num with the given reserves
+func (n *Node) DeployPair(num uint64, addr common.Address, reserve0, reserve1 *big.Int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	acc := n.account(num, addr)
+	acc.Code = PairCode()
+	acc.Storage[PairReservesSlot] = PackReserves(reserve0, reserve1, uint32(genesisTime+blockTime*num))
+}
+
+// SetReserves updates an already deployed pair's reserves as of block num
+func (n *Node) SetReserves(num uint64, addr common.Address, reserve0, reserve1 *big.Int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.account(num, addr).Storage[PairReservesSlot] = PackReserves(reserve0, reserve1, uint32(genesisTime+blockTime*num))
+}
+
+// SwapLog builds the Swap event a pair at addr would emit
+func SwapLog(pair, sender, to common.Address, amount0In, amount1In, amount0Out, amount1Out *big.Int) *types.Log {
+	data := make([]byte, 0, 128)
+	for _, v := range []*big.Int{amount0In, amount1In, amount0Out, amount1Out} {
+		data = append(data, common.BigToHash(v).Bytes()...)
+	}
+	return &types.Log{
+		Address: pair,
+		Topics: []common.Hash{
+			SwapTopic,
+			common.BytesToHash(sender.Bytes()),
+			common.BytesToHash(to.Bytes()),
+		},
+		Data: data,
+	}
+}